@@ -0,0 +1,210 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator decides who is allowed to use the admin API. A Server
+// with an Authenticator checks every API request with it. Redirects
+// are always public.
+type Authenticator interface {
+	// Authenticate returns the name of the user making the request and
+	// true if they are allowed in. Otherwise it returns false.
+	Authenticate(r *http.Request) (string, bool)
+}
+
+// Challenger is an optional interface an Authenticator can implement
+// to tell clients how to authenticate. The returned value is used as
+// the WWW-Authenticate header of 401 responses.
+type Challenger interface {
+	Challenge() string
+}
+
+// WithAuthenticator protects the API with the given Authenticator.
+func WithAuthenticator(a Authenticator) Option {
+	return func(s *Server) {
+		s.auth = a
+	}
+}
+
+// authenticate is a helper function that wraps the given handler so
+// it's only called if the request is authenticated. If the server
+// doesn't have an Authenticator, the handler is returned unchanged.
+func (s *Server) authenticate(h http.Handler) http.Handler {
+	if s.auth == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.auth.Authenticate(r); !ok {
+			if c, ok := s.auth.(Challenger); ok {
+				w.Header().Set("WWW-Authenticate", c.Challenge())
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("unauthorized"))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Authenticators lets a request in if any of its Authenticators do.
+type Authenticators []Authenticator
+
+// Authenticate implements the Authenticator interface.
+func (as Authenticators) Authenticate(r *http.Request) (string, bool) {
+	for _, a := range as {
+		if user, ok := a.Authenticate(r); ok {
+			return user, true
+		}
+	}
+
+	return "", false
+}
+
+// Challenge implements the Challenger interface. The challenge of the
+// first Authenticator that has one is used.
+func (as Authenticators) Challenge() string {
+	for _, a := range as {
+		if c, ok := a.(Challenger); ok {
+			return c.Challenge()
+		}
+	}
+
+	return ""
+}
+
+// APIKeys authenticates requests with an Authorization header of the
+// form "Bearer {key}". It maps each key to the name of its user.
+type APIKeys map[string]string
+
+// Authenticate implements the Authenticator interface.
+func (ak APIKeys) Authenticate(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	key := []byte(strings.TrimSpace(h[7:]))
+
+	// Compare against all of them so the time doesn't give away how
+	// close we were.
+	user, found := "", false
+	for k, u := range ak {
+		if subtle.ConstantTimeCompare(key, []byte(k)) == 1 {
+			user, found = u, true
+		}
+	}
+
+	return user, found
+}
+
+// Challenge implements the Challenger interface.
+func (ak APIKeys) Challenge() string {
+	return "Bearer"
+}
+
+// BasicAuth authenticates requests with HTTP Basic authentication.
+type BasicAuth struct {
+	// The realm sent to clients when challenging them.
+	Realm string
+
+	// The bcrypt hash of each user's password.
+	Users map[string]string
+}
+
+// dummyHash is compared against when a user isn't found so unknown
+// users take as long to reject as bad passwords.
+var dummyHash = []byte(
+	"$2a$10$ot.HsP9TLTi4XQhy/I7K4uQ4ajW8pBCpltePCk0eXJopl2FFUWTEu")
+
+// Authenticate implements the Authenticator interface.
+func (ba *BasicAuth) Authenticate(r *http.Request) (string, bool) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	hash, found := ba.Users[user]
+	if !found {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(pass))
+		return "", false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) != nil {
+		return "", false
+	}
+
+	return user, true
+}
+
+// Challenge implements the Challenger interface.
+func (ba *BasicAuth) Challenge() string {
+	realm := ba.Realm
+	if realm == "" {
+		realm = "urls"
+	}
+	return `Basic realm="` + strings.Replace(realm, `"`, `'`, -1) + `"`
+}
+
+// ProxyHeader authenticates requests that a trusted reverse proxy has
+// already authenticated. The proxy puts the name of the user in the
+// header. The header is only believed when the request comes directly
+// from one of the trusted networks.
+type ProxyHeader struct {
+	// The header the proxy puts the user name in.
+	Header string
+
+	// The networks the proxy connects from.
+	Trusted []*net.IPNet
+}
+
+// NewProxyHeader creates a ProxyHeader for the given header that
+// trusts the networks given in CIDR notation (e.g. 10.0.0.0/8).
+func NewProxyHeader(header string, cidrs ...string) (*ProxyHeader, error) {
+	ph := &ProxyHeader{
+		Header: header,
+	}
+
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		ph.Trusted = append(ph.Trusted, n)
+	}
+
+	return ph, nil
+}
+
+// Authenticate implements the Authenticator interface.
+func (ph *ProxyHeader) Authenticate(r *http.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", false
+	}
+
+	for _, n := range ph.Trusted {
+		if n.Contains(ip) {
+			user := r.Header.Get(ph.Header)
+			return user, user != ""
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,115 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticators(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	ph, err := NewProxyHeader("X-User", "10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("NewProxyHeader() failed: %v", err)
+	}
+
+	keys := APIKeys{"abc123": "robot"}
+	basic := &BasicAuth{Users: map[string]string{"admin": string(hash)}}
+
+	tests := []struct {
+		auth   Authenticator
+		remote string
+		header map[string]string
+		basic  []string
+		user   string
+		ok     bool
+	}{
+		// Test API keys.
+		{auth: keys, header: map[string]string{"Authorization": "Bearer abc123"},
+			user: "robot", ok: true},
+		{auth: keys, header: map[string]string{"Authorization": "Bearer abc124"}},
+		{auth: keys, header: map[string]string{"Authorization": "abc123"}},
+		{auth: keys},
+
+		// Test basic auth.
+		{auth: basic, basic: []string{"admin", "secret"}, user: "admin", ok: true},
+		{auth: basic, basic: []string{"admin", "wrong"}},
+		{auth: basic, basic: []string{"nobody", "secret"}},
+		{auth: basic},
+
+		// Test the proxy header.
+		{auth: ph, remote: "10.1.2.3:1234", header: map[string]string{"X-User": "bob"},
+			user: "bob", ok: true},
+		{auth: ph, remote: "10.1.2.3:1234"},
+		{auth: ph, remote: "192.168.1.1:1234", header: map[string]string{"X-User": "bob"}},
+
+		// Test any of them.
+		{auth: Authenticators{keys, basic}, basic: []string{"admin", "secret"},
+			user: "admin", ok: true},
+		{auth: Authenticators{keys, basic}, basic: []string{"admin", "wrong"}},
+	}
+
+	for k, test := range tests {
+		r, _ := http.NewRequest("GET", "http://localhost/api/urls", nil)
+		r.RemoteAddr = test.remote
+		for h, v := range test.header {
+			r.Header.Set(h, v)
+		}
+		if test.basic != nil {
+			r.SetBasicAuth(test.basic[0], test.basic[1])
+		}
+
+		user, ok := test.auth.Authenticate(r)
+		if user != test.user || ok != test.ok {
+			t.Errorf("Test %v: expected (%v, %v), got (%v, %v)",
+				k, test.user, test.ok, user, ok)
+		}
+	}
+}
+
+func TestServerAuthenticator(t *testing.T) {
+	s := NewServer(prep(), WithAuthenticator(APIKeys{"abc123": "robot"}))
+
+	tests := []struct {
+		path      string
+		key       string
+		code      int
+		challenge string
+	}{
+		{path: "/api/count/urls", code: http.StatusUnauthorized,
+			challenge: "Bearer"},
+		{path: "/api/count/urls", key: "abc123", code: http.StatusOK},
+		{path: "/api/stats/1c", code: http.StatusUnauthorized,
+			challenge: "Bearer"},
+		{path: "/api/unknown", code: http.StatusUnauthorized,
+			challenge: "Bearer"},
+
+		// Redirects are always public.
+		{path: "/1c", code: http.StatusFound},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		if test.key != "" {
+			r.Header.Set("Authorization", "Bearer "+test.key)
+		}
+
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected code %v, got %v", k, test.code, w.Code)
+		}
+
+		if c := w.Header().Get("WWW-Authenticate"); c != test.challenge {
+			t.Errorf("Test %v: expected challenge %q, got %q",
+				k, test.challenge, c)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package boltdb is an implementation of the urls.DataStore that
+// keeps everything in a single bbolt file. It's meant for small self
+// hosted instances where running a database server isn't worth it.
+package boltdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/icub3d/urls"
+	bolt "go.etcd.io/bbolt"
+)
+
+// These are the top level buckets.
+var (
+	// urlsBucket maps the short id to the JSON encoded url. Its
+	// sequence is used to create new ids.
+	urlsBucket = []byte("urls")
+
+	// createdBucket is an index of the urls by their create date. The
+	// keys are the created time followed by the short id.
+	createdBucket = []byte("created")
+
+	// logsBucket contains a bucket for each short id. The keys in those
+	// are the time of the click followed by a sequence number.
+	logsBucket = []byte("logs")
+
+	// statsBucket maps the short id to the JSON encoded statistics.
+	statsBucket = []byte("stats")
+
+	// longsBucket is an index of the urls by their long url. The keys
+	// are the hash of the long url followed by the short id so they
+	// stay small no matter how long the url is.
+	longsBucket = []byte("longs")
+)
+
+// DataStore implements the urls.DataStore interface.
+type DataStore struct {
+	db *bolt.DB
+}
+
+// NewDataStore creates a new datastore using the given database. The
+// buckets are created if they don't exist.
+func NewDataStore(db *bolt.DB) (*DataStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{urlsBucket, createdBucket, logsBucket,
+			statsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+
+		// Files from before the long url index need it built.
+		if tx.Bucket(longsBucket) != nil {
+			return nil
+		}
+
+		lb, err := tx.CreateBucket(longsBucket)
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(urlsBucket).ForEach(func(k, v []byte) error {
+			u := &urls.URL{}
+			if err := json.Unmarshal(v, u); err != nil {
+				return err
+			}
+			return lb.Put(longKey(u.Long, k), k)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataStore{
+		db: db,
+	}, nil
+}
+
+// timeKey returns a key for the given time that sorts in time order,
+// followed by the given suffix.
+func timeKey(t time.Time, suffix []byte) []byte {
+	k := make([]byte, 8, 8+len(suffix))
+	// Flipping the sign bit makes times before 1970 sort first.
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano())^(1<<63))
+	return append(k, suffix...)
+}
+
+// longKey returns the key in the long url index for the given long
+// url and short id.
+func longKey(long string, short []byte) []byte {
+	h := sha256.Sum256([]byte(long))
+	return append(h[:], short...)
+}
+
+// CountURLs implements the urls.DataStore interface.
+func (ds *DataStore) CountURLs() (int, error) {
+	var c int
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		c = tx.Bucket(urlsBucket).Stats().KeyN
+		return nil
+	})
+	return c, err
+}
+
+// GetURLs implements the urls.DataStore interface.
+func (ds *DataStore) GetURLs(limit, offset int) ([]*urls.URL, error) {
+	us := make([]*urls.URL, 0, limit)
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+
+		// Walk the index backwards to get the newest first.
+		c := tx.Bucket(createdBucket).Cursor()
+		x := 0
+		for k, v := c.Last(); k != nil && len(us) < limit; k, v = c.Prev() {
+			if x < offset {
+				x++
+				continue
+			}
+
+			u := &urls.URL{}
+			if err := json.Unmarshal(ub.Get(v), u); err != nil {
+				return err
+			}
+			us = append(us, u)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return us, nil
+}
+
+// GetURL implements the urls.DataStore interface.
+func (ds *DataStore) GetURL(short string) (*urls.URL, error) {
+	u := &urls.URL{}
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(urlsBucket).Get([]byte(short))
+		if data == nil {
+			return urls.ErrNotFound
+		}
+
+		return json.Unmarshal(data, u)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// DeleteURL implements the urls.DataStore interface.
+func (ds *DataStore) DeleteURL(short string) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+		key := []byte(short)
+
+		data := ub.Get(key)
+		if data == nil {
+			return urls.ErrNotFound
+		}
+
+		u := &urls.URL{}
+		if err := json.Unmarshal(data, u); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(createdBucket).Delete(
+			timeKey(u.Created, key)); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(longsBucket).Delete(
+			longKey(u.Long, key)); err != nil {
+			return err
+		}
+
+		// Delete the logs.
+		lb := tx.Bucket(logsBucket)
+		if lb.Bucket(key) != nil {
+			if err := lb.DeleteBucket(key); err != nil {
+				return err
+			}
+		}
+
+		// Delete the stats.
+		if err := tx.Bucket(statsBucket).Delete(key); err != nil {
+			return err
+		}
+
+		return ub.Delete(key)
+	})
+}
+
+// PutURL implements the urls.DataStore interface.
+func (ds *DataStore) PutURL(u *urls.URL) (string, error) {
+	short := u.Short
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+		cb := tx.Bucket(createdBucket)
+		lb := tx.Bucket(longsBucket)
+
+		// We may need to create an ID. Skip any that were taken by an
+		// alias.
+		for short == "" {
+			i, err := ub.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			short = urls.IntToShort(int64(i))
+			if ub.Get([]byte(short)) != nil {
+				short = ""
+			}
+		}
+		key := []byte(short)
+
+		// If we are overwriting, the old index entries need to go.
+		if data := ub.Get(key); data != nil {
+			old := &urls.URL{}
+			if err := json.Unmarshal(data, old); err != nil {
+				return err
+			}
+
+			if err := cb.Delete(timeKey(old.Created, key)); err != nil {
+				return err
+			}
+
+			if err := lb.Delete(longKey(old.Long, key)); err != nil {
+				return err
+			}
+		}
+
+		c := *u
+		c.Short = short
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		if err := cb.Put(timeKey(u.Created, key), key); err != nil {
+			return err
+		}
+
+		if err := lb.Put(longKey(u.Long, key), key); err != nil {
+			return err
+		}
+
+		return ub.Put(key, data)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	u.Short = short
+	return u.Short, nil
+}
+
+// CreateURL implements the urls.URLCreator interface.
+func (ds *DataStore) CreateURL(u *urls.URL) error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+		key := []byte(u.Short)
+
+		if ub.Get(key) != nil {
+			return urls.ErrExists
+		}
+
+		if err := tx.Bucket(createdBucket).Put(timeKey(u.Created, key),
+			key); err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(longsBucket).Put(longKey(u.Long, key),
+			key); err != nil {
+			return err
+		}
+
+		return ub.Put(key, data)
+	})
+}
+
+// UpdateURL implements the urls.URLUpdater interface.
+func (ds *DataStore) UpdateURL(u *urls.URL, version int) error {
+	c := *u
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+		lb := tx.Bucket(longsBucket)
+		key := []byte(u.Short)
+
+		data := ub.Get(key)
+		if data == nil {
+			return urls.ErrNotFound
+		}
+
+		old := &urls.URL{}
+		if err := json.Unmarshal(data, old); err != nil {
+			return err
+		} else if old.Version != version {
+			return urls.ErrVersion
+		}
+
+		c.Created = old.Created
+		c.Clicks = old.Clicks
+		c.Version = version + 1
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+
+		// The created index doesn't change, but the long one might.
+		if err := lb.Delete(longKey(old.Long, key)); err != nil {
+			return err
+		}
+
+		if err := lb.Put(longKey(c.Long, key), key); err != nil {
+			return err
+		}
+
+		return ub.Put(key, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	*u = c
+	return nil
+}
+
+// AddClick implements the urls.ClickCounter interface.
+func (ds *DataStore) AddClick(short string) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+		key := []byte(short)
+
+		data := ub.Get(key)
+		if data == nil {
+			return urls.ErrNotFound
+		}
+
+		u := &urls.URL{}
+		if err := json.Unmarshal(data, u); err != nil {
+			return err
+		}
+
+		u.Clicks++
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+
+		return ub.Put(key, data)
+	})
+}
+
+// FindURL implements the urls.URLFinder interface.
+func (ds *DataStore) FindURL(long string) (*urls.URL, error) {
+	var u *urls.URL
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		ub := tx.Bucket(urlsBucket)
+
+		prefix := longKey(long, nil)
+		c := tx.Bucket(longsBucket).Cursor()
+		k, v := c.Seek(prefix)
+		for ; bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			u = &urls.URL{}
+			if err := json.Unmarshal(ub.Get(v), u); err != nil {
+				return err
+			}
+
+			if u.Long == long {
+				return nil
+			}
+		}
+
+		u = nil
+		return urls.ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// NextID implements the urls.Sequencer interface. It shares the
+// sequence used to create ids in PutURL.
+func (ds *DataStore) NextID() (int64, error) {
+	var n int64
+	err := ds.db.Update(func(tx *bolt.Tx) error {
+		i, err := tx.Bucket(urlsBucket).NextSequence()
+		n = int64(i)
+		return err
+	})
+	return n, err
+}
+
+// GetStatistics implements the urls.DataStore interface.
+func (ds *DataStore) GetStatistics(short string) (*urls.Statistics, error) {
+	stats := urls.NewStatistics(short)
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(statsBucket).Get([]byte(short))
+		if data == nil {
+			return urls.ErrNotFound
+		}
+
+		return json.Unmarshal(data, stats)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// PutStatistics implements the urls.DataStore interface.
+func (ds *DataStore) PutStatistics(stats *urls.Statistics) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(statsBucket).Put([]byte(stats.Short), data)
+	})
+}
+
+// AddStatistics implements the urls.StatisticsCounter interface.
+func (ds *DataStore) AddStatistics(stats *urls.Statistics) error {
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		sb := tx.Bucket(statsBucket)
+		key := []byte(stats.Short)
+
+		s := urls.NewStatistics(stats.Short)
+		if data := sb.Get(key); data != nil {
+			if err := json.Unmarshal(data, s); err != nil {
+				return err
+			}
+		}
+
+		s.Add(stats)
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+
+		return sb.Put(key, data)
+	})
+}
+
+// LogClick implements the urls.DataStore interface.
+func (ds *DataStore) LogClick(l *urls.Log) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return ds.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(logsBucket).CreateBucketIfNotExists(
+			[]byte(l.Short))
+		if err != nil {
+			return err
+		}
+
+		// The sequence keeps clicks at the same time from colliding.
+		i, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		seq := make([]byte, 8)
+		binary.BigEndian.PutUint64(seq, i)
+
+		return b.Put(timeKey(l.When, seq), data)
+	})
+}
+
+// CountLogs implements the urls.DataStore interface.
+func (ds *DataStore) CountLogs(short string) (int, error) {
+	var c int
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(logsBucket).Bucket([]byte(short)); b != nil {
+			c = b.Stats().KeyN
+		}
+		return nil
+	})
+	return c, err
+}
+
+// GetLogs implements the urls.DataStore interface.
+func (ds *DataStore) GetLogs(short string, limit, offset int) ([]*urls.Log,
+	error) {
+
+	ls := make([]*urls.Log, 0, limit)
+	err := ds.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(logsBucket).Bucket([]byte(short))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		x := 0
+		for k, v := c.First(); k != nil && len(ls) < limit; k, v = c.Next() {
+			if x < offset {
+				x++
+				continue
+			}
+
+			l := &urls.Log{}
+			if err := json.Unmarshal(v, l); err != nil {
+				return err
+			}
+			ls = append(ls, l)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ls, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/icub3d/urls"
+	"github.com/icub3d/urls/urlstest"
+	bolt "go.etcd.io/bbolt"
+)
+
+// open is a helper function that opens a new database in a temporary
+// directory.
+func open(t *testing.T) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "urls.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("bolt.Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestDataStoreSuite(t *testing.T) {
+	urlstest.RunDataStoreSuite(t, func(t *testing.T) urls.DataStore {
+		ds, err := NewDataStore(open(t))
+		if err != nil {
+			t.Fatalf("NewDataStore() failed: %v", err)
+		}
+
+		return ds
+	})
+}
+
+func TestTimeKey(t *testing.T) {
+	tests := []struct {
+		a time.Time
+		b time.Time
+	}{
+		{
+			a: time.Date(1969, time.January, 1, 0, 0, 0, 0, time.UTC),
+			b: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			a: time.Date(2013, time.August, 1, 0, 0, 0, 0, time.UTC),
+			b: time.Date(2013, time.August, 1, 0, 0, 0, 1, time.UTC),
+		},
+	}
+
+	for k, test := range tests {
+		a := string(timeKey(test.a, []byte("a")))
+		b := string(timeKey(test.b, []byte("a")))
+		if a >= b {
+			t.Errorf("Test %v: expected key for %v to sort before %v",
+				k, test.a, test.b)
+		}
+	}
+}
+
+func TestLongIndexBuilt(t *testing.T) {
+	db := open(t)
+
+	ds, err := NewDataStore(db)
+	if err != nil {
+		t.Fatalf("NewDataStore() failed: %v", err)
+	}
+
+	u := &urls.URL{Long: "http://example.com/", Created: time.Now()}
+	if _, err := ds.PutURL(u); err != nil {
+		t.Fatalf("PutURL() failed: %v", err)
+	}
+
+	// Pretend the file is from before the index existed.
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(longsBucket)
+	})
+	if err != nil {
+		t.Fatalf("DeleteBucket() failed: %v", err)
+	}
+
+	ds, err = NewDataStore(db)
+	if err != nil {
+		t.Fatalf("NewDataStore() failed on existing file: %v", err)
+	}
+
+	got, err := ds.FindURL(u.Long)
+	if err != nil || got.Short != u.Short {
+		t.Errorf("expected FindURL(%v) to find %v, got %v, %v",
+			u.Long, u.Short, got, err)
+	}
+}
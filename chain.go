@@ -0,0 +1,88 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"net/url"
+	"strings"
+)
+
+// WithDomains sets the domains the server is reachable at. Long urls
+// on these domains point back at the server, so they are followed
+// when a url is created to make sure they don't loop or form a chain
+// longer than the limit set with WithMaxChain.
+func WithDomains(domains ...string) Option {
+	return func(s *Server) {
+		s.domains = make(map[string]bool)
+		for _, d := range domains {
+			d = strings.TrimSpace(d)
+			if a, err := asciiHost(d); err == nil {
+				d = a
+			}
+			if d != "" {
+				s.domains[strings.ToLower(d)] = true
+			}
+		}
+	}
+}
+
+// WithMaxChain sets how many of the server's own short urls a long url
+// can go through before it gets to somewhere else. It defaults to 0,
+// which means long urls can't point at the server at all.
+func WithMaxChain(n int) Option {
+	return func(s *Server) {
+		s.maxChain = n
+	}
+}
+
+// checkChain is a helper function that follows the long url of u
+// through the server's own short urls. It returns an *InvalidURLError
+// if it points at something other than a short url, loops back on
+// itself or goes through more than maxChain short urls.
+func (s *Server) checkChain(ds DataStore, u *URL) error {
+	invalid := func(reason string) error {
+		return &InvalidURLError{URL: u.Long, Reason: reason}
+	}
+
+	seen := map[string]bool{}
+	if u.Short != "" {
+		seen[u.Short] = true
+	}
+
+	long := u.Long
+	for depth := 1; ; depth++ {
+		l, err := url.Parse(long)
+		if err != nil || !s.domains[strings.ToLower(l.Hostname())] {
+			return nil
+		}
+
+		if depth > s.maxChain {
+			if s.maxChain == 0 {
+				return invalid("url points at this shortener")
+			}
+			return invalid("url goes through too many short urls")
+		}
+
+		c := s.idCodec()
+		id := c.Normalize(strings.TrimPrefix(l.EscapedPath(), "/"))
+		if !c.Valid(id) {
+			return invalid("url isn't a short url on this shortener")
+		}
+
+		if seen[id] {
+			return invalid("url redirects back to itself")
+		}
+		seen[id] = true
+
+		next, err := ds.GetURL(id)
+		if err == ErrNotFound || (err == nil && next == nil) {
+			return invalid("url points at a short url that doesn't exist")
+		} else if err != nil {
+			return err
+		}
+
+		long = next.Long
+	}
+}
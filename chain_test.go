@@ -0,0 +1,81 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckChain(t *testing.T) {
+	ds := prep()
+
+	// 1 -> 2 -> 3 -> somewhere else and 4 -> 5 -> 4.
+	for _, u := range []*URL{
+		{Short: "chain1", Long: "https://sho.rt/chain2"},
+		{Short: "chain2", Long: "https://SHO.RT/chain3"},
+		{Short: "chain3", Long: "http://example.com/"},
+		{Short: "loop4", Long: "https://sho.rt/loop5"},
+		{Short: "loop5", Long: "https://sho.rt/loop4"},
+	} {
+		ds.PutURL(u)
+	}
+
+	tests := []struct {
+		max   int
+		alias string
+		long  string
+		code  int
+	}{
+		// Test urls that don't point at us.
+		{long: "http://example.com/", code: http.StatusOK},
+		{long: "http://sho.rt.example.com/1c", code: http.StatusOK},
+
+		// Test the default of no chains.
+		{long: "https://sho.rt/1c", code: http.StatusBadRequest},
+		{long: "https://sho.rt:8443/1c", code: http.StatusBadRequest},
+		{long: "https://go.sho.rt/1c", code: http.StatusBadRequest},
+
+		// Test chains up to the limit.
+		{max: 1, long: "https://sho.rt/1c", code: http.StatusOK},
+		{max: 1, long: "https://sho.rt/chain3", code: http.StatusOK},
+		{max: 1, long: "https://sho.rt/chain2", code: http.StatusBadRequest},
+		{max: 3, long: "https://sho.rt/chain1", code: http.StatusOK},
+		{max: 2, long: "https://sho.rt/chain1", code: http.StatusBadRequest},
+
+		// Test things that aren't short urls.
+		{max: 1, long: "https://sho.rt/", code: http.StatusBadRequest},
+		{max: 1, long: "https://sho.rt/api/urls", code: http.StatusBadRequest},
+		{max: 1, long: "https://sho.rt/missing", code: http.StatusBadRequest},
+
+		// Test loops.
+		{max: 10, long: "https://sho.rt/loop4", code: http.StatusBadRequest},
+		{max: 10, alias: "self", long: "https://sho.rt/self",
+			code: http.StatusBadRequest},
+		{max: 10, alias: "back", long: "https://sho.rt/back2",
+			code: http.StatusBadRequest},
+	}
+	ds.PutURL(&URL{Short: "back2", Long: "https://sho.rt/back"})
+
+	for k, test := range tests {
+		s := NewServer(ds, WithDomains("sho.rt", "go.sho.rt"),
+			WithMaxChain(test.max))
+
+		var b bytes.Buffer
+		b.Write([]byte(`{"Long":"` + test.long + `","Alias":"` +
+			test.alias + `"}`))
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/urls", &b)
+
+		s.newURL(ds, w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: %v: expected %v, got %v: %v",
+				k, test.long, test.code, w.Code, w.Body.String())
+		}
+	}
+}
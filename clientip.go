@@ -0,0 +1,163 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientResolver finds the address of the client that made a request.
+// It's used for the click logs, the country in the statistics and
+// limiting password attempts.
+type ClientResolver interface {
+	// ClientAddr returns the address of the client. It may have a
+	// port.
+	ClientAddr(r *http.Request) string
+}
+
+// WithClientResolver uses the given ClientResolver to find the
+// address of clients. Without one, the address the request came from
+// (http.Request.RemoteAddr) is used, which is the address of the proxy
+// if there is one.
+func WithClientResolver(c ClientResolver) Option {
+	return func(s *Server) {
+		s.clients = c
+	}
+}
+
+// TrustedProxies is a ClientResolver for servers behind reverse proxies
+// or load balancers. Requests from the trusted networks have the
+// client's address taken from the header the proxies set, like
+// Forwarded (RFC 7239), X-Forwarded-For or X-Real-IP. Addresses in the
+// header are read from the right, skipping the trusted ones, so a
+// client can't pretend to be somewhere else by sending the header
+// itself. Requests from anywhere else use their own address.
+//
+// Only the one header is read. Proxies usually pass along the headers
+// they don't set, so any other header could have come from the client.
+type TrustedProxies struct {
+	// The header the proxies add the client's address to. Forwarded
+	// is read as RFC 7239 and anything else as a comma separated list
+	// of addresses.
+	Header string
+
+	// The networks the proxies connect from.
+	Trusted []*net.IPNet
+}
+
+// NewTrustedProxies creates a TrustedProxies that reads the client's
+// address from the given header of requests from the networks given
+// in CIDR notation (e.g. 10.0.0.0/8).
+func NewTrustedProxies(header string, cidrs ...string) (*TrustedProxies,
+	error) {
+	if header == "" {
+		return nil, errors.New("the header the proxies set is required")
+	}
+
+	tp := &TrustedProxies{Header: http.CanonicalHeaderKey(header)}
+
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+
+		tp.Trusted = append(tp.Trusted, n)
+	}
+
+	return tp, nil
+}
+
+// ClientAddr implements the ClientResolver interface.
+func (tp *TrustedProxies) ClientAddr(r *http.Request) string {
+	addr := r.RemoteAddr
+	if tp.Header == "" || !tp.trusted(addr) {
+		return addr
+	}
+
+	var hops []string
+	if http.CanonicalHeaderKey(tp.Header) == "Forwarded" {
+		hops = forwardedFor(r.Header)
+	} else {
+		hops = splitList(r.Header.Values(tp.Header))
+	}
+
+	// Work back from the closest proxy until we find one we don't
+	// trust. Anything before that could have been made up.
+	for x := len(hops) - 1; x >= 0; x-- {
+		if _, ok := parseAddr(hops[x]); !ok {
+			break
+		}
+
+		addr = hops[x]
+		if !tp.trusted(addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// trusted is a helper function that returns true if the address is in
+// one of the trusted networks.
+func (tp *TrustedProxies) trusted(addr string) bool {
+	ip, ok := parseAddr(addr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range tp.Trusted {
+		if n.Contains(net.IP(ip.AsSlice())) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedFor is a helper function that returns the for parameters of
+// the Forwarded headers in order.
+//
+//	Forwarded: for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"
+func forwardedFor(h http.Header) []string {
+	var hops []string
+	for _, elem := range splitList(h["Forwarded"]) {
+		for _, pair := range strings.Split(elem, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+				hops = append(hops, strings.Trim(kv[1], `"`))
+			}
+		}
+	}
+
+	return hops
+}
+
+// splitList is a helper function that splits the comma separated
+// values of headers that can be given more than once.
+func splitList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+
+	return list
+}
+
+// clientAddr is a helper function that returns the address of the
+// client that made the request using the server's ClientResolver.
+func (s *Server) clientAddr(r *http.Request) string {
+	if s.clients != nil {
+		return s.clients.ClientAddr(r)
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,140 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	tests := []struct {
+		header   string
+		remote   string
+		headers  map[string][]string
+		expected string
+	}{
+		// Untrusted requests use their own address.
+		{remote: "1.2.3.4:5678", expected: "1.2.3.4:5678"},
+		{remote: "1.2.3.4:5678",
+			headers:  map[string][]string{"X-Forwarded-For": {"5.6.7.8"}},
+			expected: "1.2.3.4:5678"},
+
+		// Trusted requests without a header use their own address.
+		{remote: "10.0.0.1:80", expected: "10.0.0.1:80"},
+
+		// X-Forwarded-For is read from the right, skipping proxies.
+		{remote: "10.0.0.1:80",
+			headers:  map[string][]string{"X-Forwarded-For": {"5.6.7.8"}},
+			expected: "5.6.7.8"},
+		{remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"9.9.9.9, 5.6.7.8, 10.0.0.2"}},
+			expected: "5.6.7.8"},
+		{remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"9.9.9.9, 5.6.7.8", "10.0.0.2"}},
+			expected: "5.6.7.8"},
+		{remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected: "10.0.0.3"},
+
+		// Garbage stops at the last address we trust.
+		{remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"5.6.7.8, garbage, 10.0.0.2"}},
+			expected: "10.0.0.2"},
+
+		// Only the configured header is read, so headers the proxy
+		// passed along from the client are ignored.
+		{remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"Forwarded":       {"for=6.6.6.6"},
+				"X-Real-Ip":       {"6.6.6.6"},
+				"X-Forwarded-For": {"203.0.113.9"}},
+			expected: "203.0.113.9"},
+		{header: "X-Real-IP", remote: "10.0.0.1:80",
+			headers: map[string][]string{"X-Real-Ip": {"5.6.7.8"},
+				"X-Forwarded-For": {"9.9.9.9"}},
+			expected: "5.6.7.8"},
+		{header: "Forwarded", remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"X-Forwarded-For": {"6.6.6.6"}},
+			expected: "10.0.0.1:80"},
+
+		// Forwarded can have IPv6 and ports.
+		{header: "Forwarded", remote: "[fd00::1]:80",
+			headers: map[string][]string{
+				"Forwarded": {`for=192.0.2.60;proto=http;by=10.0.0.1`}},
+			expected: "192.0.2.60"},
+		{header: "Forwarded", remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"Forwarded": {`for="[2001:db8::17]:4711", For=10.0.0.2`}},
+			expected: "[2001:db8::17]:4711"},
+		{header: "Forwarded", remote: "10.0.0.1:80",
+			headers: map[string][]string{
+				"Forwarded": {`for=unknown, for=10.0.0.2`}},
+			expected: "10.0.0.2"},
+	}
+
+	for k, test := range tests {
+		header := test.header
+		if header == "" {
+			header = "X-Forwarded-For"
+		}
+		tp, err := NewTrustedProxies(header, "10.0.0.0/8", "fd00::/8")
+		if err != nil {
+			t.Fatalf("Test %v: NewTrustedProxies() failed: %v", k, err)
+		}
+
+		r, _ := http.NewRequest("GET", "http://localhost/1c", nil)
+		r.RemoteAddr = test.remote
+		for h, v := range test.headers {
+			r.Header[h] = v
+		}
+
+		if addr := tp.ClientAddr(r); addr != test.expected {
+			t.Errorf("Test %v: expected %q, got %q", k, test.expected, addr)
+		}
+	}
+
+	if _, err := NewTrustedProxies("X-Forwarded-For", "10.0.0.0"); err == nil {
+		t.Errorf("expected an error for an invalid network")
+	}
+
+	if _, err := NewTrustedProxies("", "10.0.0.0/8"); err == nil {
+		t.Errorf("expected an error without a header")
+	}
+}
+
+func TestRedirectClientAddr(t *testing.T) {
+	ds := prep()
+
+	tp, _ := NewTrustedProxies("X-Forwarded-For", "10.0.0.0/8")
+	g, err := OpenGeoDB("testdata/countries.csv")
+	if err != nil {
+		t.Fatalf("OpenGeoDB() failed: %v", err)
+	}
+	s := NewServer(ds, WithClientResolver(tp), WithGeoResolver(g))
+
+	r, _ := http.NewRequest("GET", "http://localhost/1c", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "206.251.44.94")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+
+	logs := ds.logs["1c"]
+	if len(logs) == 0 || logs[len(logs)-1].Addr != "206.251.44.94" {
+		t.Errorf("expected the click to be logged from 206.251.44.94, got %v",
+			logs)
+	}
+
+	stats, _ := ds.GetStatistics("1c")
+	if stats == nil || stats.Countries["US"] != 1 {
+		t.Errorf("expected a click from the US, got %v", stats)
+	}
+}
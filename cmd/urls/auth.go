@@ -0,0 +1,107 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/icub3d/urls"
+)
+
+var (
+	apiKeys = flag.String("api-keys", "",
+		"a file of API keys, one 'user key' pair per line")
+	htpasswd = flag.String("htpasswd", "",
+		"a file of 'user:bcrypt-hash' lines for HTTP Basic authentication")
+	authHeader = flag.String("auth-header", "",
+		"a header a trusted reverse proxy puts the authenticated user in")
+	authProxies = flag.String("auth-proxies", "",
+		"comma separated CIDRs the -auth-header proxy connects from")
+)
+
+// newAuthenticator creates the authenticator from the flags. If none
+// of them are set, nil is returned and the API isn't protected.
+func newAuthenticator() (urls.Authenticator, error) {
+	var as urls.Authenticators
+
+	if *apiKeys != "" {
+		keys := urls.APIKeys{}
+		err := readLines(*apiKeys, func(line string) error {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return fmt.Errorf("expected 'user key' but got %q", line)
+			}
+			keys[fields[1]] = fields[0]
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, keys)
+	}
+
+	if *htpasswd != "" {
+		ba := &urls.BasicAuth{Users: map[string]string{}}
+		err := readLines(*htpasswd, func(line string) error {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("expected 'user:hash' but got %q", line)
+			}
+			ba.Users[parts[0]] = parts[1]
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, ba)
+	}
+
+	if *authHeader != "" {
+		if *authProxies == "" {
+			return nil, fmt.Errorf("-auth-header requires -auth-proxies")
+		}
+
+		ph, err := urls.NewProxyHeader(*authHeader,
+			strings.Split(*authProxies, ",")...)
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, ph)
+	}
+
+	if len(as) == 0 {
+		return nil, nil
+	}
+
+	return as, nil
+}
+
+// readLines is a helper function that calls f for each line in the
+// given file that isn't blank or a comment.
+func readLines(path string, f func(line string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := f(line); err != nil {
+			return fmt.Errorf("%v: %v", path, err)
+		}
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,291 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Command urls runs the URL shortener as a standalone web server.
+//
+// The datastore is chosen with the -store flag:
+//
+//	memory  keeps everything in memory (the default).
+//	sql     uses a SQLite database at the path given by -db.
+//	bolt    uses a bbolt file at the path given by -db.
+//
+// If -cert and -key are both given, the server listens for HTTPS
+// connections. The server shuts down gracefully on SIGINT or SIGTERM.
+//
+// Destinations can be limited with a -policy file of domains to allow
+// and block (see urls.LoadDomainPolicy). It's reloaded on SIGHUP.
+//
+// The API is protected if any of -api-keys, -htpasswd or -auth-header
+// are given. Without them, anyone can use it, so only do that behind
+// something else that protects /api/.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/icub3d/urls"
+	"github.com/icub3d/urls/boltdb"
+	"github.com/icub3d/urls/memory"
+	"github.com/icub3d/urls/sqldb"
+	_ "github.com/mattn/go-sqlite3"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	addr    = flag.String("addr", ":8080", "the address to listen on")
+	store   = flag.String("store", "memory", "the datastore to use: memory, sql or bolt")
+	dbPath  = flag.String("db", "urls.db", "the database file for the sql and bolt stores")
+	cert    = flag.String("cert", "", "the TLS certificate file")
+	key     = flag.String("key", "", "the TLS key file")
+	timeout = flag.Duration("shutdown-timeout", 10*time.Second,
+		"how long to wait for requests to finish when shutting down")
+	ids = flag.String("ids", "sequential",
+		"how short ids are created: sequential, random or obfuscated")
+	idLength = flag.Int("id-length", 8, "the length of random ids")
+	alphabet = flag.String("alphabet", "base62",
+		"the id alphabet: base62, base58, base36 or the characters to use")
+	idKey = flag.String("id-key", os.Getenv("URLS_ID_KEY"),
+		"the secret key for obfuscated ids (defaults to $URLS_ID_KEY)")
+	schemes = flag.String("schemes", "http,https",
+		"the comma separated schemes long urls may use")
+	dedupe = flag.Bool("dedupe", false,
+		"return the existing short url for a long url instead of creating another")
+	domains = flag.String("domains", "",
+		"the comma separated domains the server is reachable at")
+	maxChain = flag.Int("max-chain", 0,
+		"how many of our own short urls a long url can go through")
+	policy = flag.String("policy", "",
+		"a file of domains to allow and block (reloaded on SIGHUP)")
+	blockedStatus = flag.Int("blocked-status", http.StatusGone,
+		"the status code of redirects to blocked domains")
+	comingSoon = flag.String("coming-soon", "",
+		"where to send people for links that aren't active yet")
+	passwordAttempts = flag.Int("password-attempts", 5,
+		"how many wrong passwords a client can try for a link each minute")
+	redirectStatus = flag.Int("redirect-status", http.StatusFound,
+		"the status code of redirects: 301, 302, 307 or 308")
+	redirectMaxAge = flag.Duration("redirect-max-age", 0,
+		"how long 301 and 308 redirects can be cached (clicks from caches aren't logged)")
+	geoip = flag.String("geoip", "",
+		"a country MaxMind DB (.mmdb) file or CSV file of network,country or start,end,country lines to find where clicks come from")
+	geoipCheck = flag.Duration("geoip-check", time.Minute,
+		"how often to check the -geoip file for changes")
+	trustedProxies = flag.String("trusted-proxies", "",
+		"comma separated networks of proxies whose -proxy-header is trusted")
+	proxyHeader = flag.String("proxy-header", "",
+		"the header the -trusted-proxies put the client's address in: Forwarded, X-Forwarded-For, X-Real-IP, ...")
+	userAgents = flag.String("user-agents", "",
+		"a user agent rules file to use instead of the built in rules")
+)
+
+func main() {
+	flag.Parse()
+
+	var codec *urls.Codec
+	switch *alphabet {
+	case "base62":
+		codec = urls.Base62
+	case "base58":
+		codec = urls.Base58
+	case "base36":
+		codec = urls.Base36
+	default:
+		c, err := urls.NewCodec(*alphabet)
+		if err != nil {
+			log.Fatalf("invalid -alphabet: %v", err)
+		}
+		codec = c
+	}
+
+	if (*cert == "") != (*key == "") {
+		log.Fatalf("-cert and -key have to be given together")
+	}
+
+	// Everything is checked before the datastore is opened since
+	// log.Fatalf doesn't give us a chance to close it.
+	var opts []urls.Option
+	auth, err := newAuthenticator()
+	if err != nil {
+		log.Fatalf("setting up authentication failed: %v", err)
+	} else if auth != nil {
+		opts = append(opts, urls.WithAuthenticator(auth))
+	} else {
+		log.Printf("warning: the API is not protected by any authentication")
+	}
+
+	opts = append(opts, urls.WithCodec(codec))
+	switch *ids {
+	case "sequential":
+		// The datastores create sequential ids with the DefaultCodec, so
+		// we have to create them ourselves for any other alphabet.
+		if codec != urls.DefaultCodec {
+			opts = append(opts, urls.WithIDGenerator(&urls.SequentialIDs{
+				Codec: codec,
+			}))
+		}
+	case "random":
+		opts = append(opts, urls.WithIDGenerator(&urls.RandomIDs{
+			Length: *idLength,
+			Codec:  codec,
+		}))
+	case "obfuscated":
+		if *idKey == "" {
+			log.Fatalf("obfuscated ids require -id-key or $URLS_ID_KEY")
+		}
+		opts = append(opts, urls.WithIDGenerator(&urls.ObfuscatedIDs{
+			Key:   []byte(*idKey),
+			Codec: codec,
+		}))
+	default:
+		log.Fatalf("unknown id generator %q", *ids)
+	}
+
+	opts = append(opts, urls.WithSchemes(strings.Split(*schemes, ",")...))
+	if *dedupe {
+		opts = append(opts, urls.WithDeduplication())
+	}
+	if *domains != "" {
+		opts = append(opts, urls.WithDomains(strings.Split(*domains, ",")...),
+			urls.WithMaxChain(*maxChain))
+	}
+	if *blockedStatus < 300 || *blockedStatus > 599 {
+		log.Fatalf("invalid -blocked-status %v", *blockedStatus)
+	}
+	if *policy != "" {
+		p, err := newFilePolicy(*policy)
+		if err != nil {
+			log.Fatalf("loading -policy failed: %v", err)
+		}
+		opts = append(opts, urls.WithDestinationPolicy(p),
+			urls.WithBlockedStatus(*blockedStatus))
+	}
+	if *comingSoon != "" {
+		opts = append(opts, urls.WithComingSoon(
+			http.RedirectHandler(*comingSoon, http.StatusFound)))
+	}
+	opts = append(opts, urls.WithPasswordAttempts(*passwordAttempts,
+		time.Minute))
+	switch *redirectStatus {
+	case http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		opts = append(opts, urls.WithRedirectStatus(*redirectStatus),
+			urls.WithRedirectMaxAge(*redirectMaxAge))
+	default:
+		log.Fatalf("unknown -redirect-status %v", *redirectStatus)
+	}
+	if *geoip != "" {
+		g, err := urls.OpenGeoDB(*geoip)
+		if err != nil {
+			log.Fatalf("loading -geoip failed: %v", err)
+		}
+		defer g.Close()
+		go g.Watch(*geoipCheck)
+		opts = append(opts, urls.WithGeoResolver(g))
+	}
+	if *trustedProxies != "" {
+		if *proxyHeader == "" {
+			log.Fatalf("-trusted-proxies needs a -proxy-header")
+		}
+		tp, err := urls.NewTrustedProxies(*proxyHeader,
+			strings.Split(*trustedProxies, ",")...)
+		if err != nil {
+			log.Fatalf("parsing -trusted-proxies failed: %v", err)
+		}
+		opts = append(opts, urls.WithClientResolver(tp))
+	}
+	if *userAgents != "" {
+		p, err := urls.LoadUAParser(*userAgents)
+		if err != nil {
+			log.Fatalf("loading -user-agents failed: %v", err)
+		}
+		opts = append(opts, urls.WithUserAgentParser(p))
+	}
+
+	ds, closer, err := openDataStore(*store, *dbPath)
+	if err != nil {
+		log.Fatalf("opening %v datastore failed: %v", *store, err)
+	}
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: urls.NewServer(ds, opts...),
+	}
+
+	// Shutdown when we are told to.
+	done := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		log.Printf("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown() failed: %v", err)
+		}
+		close(done)
+	}()
+
+	log.Printf("listening on %v", *addr)
+	if *cert != "" {
+		err = srv.ListenAndServeTLS(*cert, *key)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		closer()
+		log.Fatalf("ListenAndServe() failed: %v", err)
+	}
+
+	<-done
+	closer()
+}
+
+// openDataStore creates the datastore with the given name. The
+// returned function should be called to clean up when done.
+func openDataStore(name, path string) (urls.DataStore, func(), error) {
+	switch name {
+	case "memory":
+		return memory.NewDataStore(), func() {}, nil
+
+	case "sql":
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ds, err := sqldb.NewDataStore(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return ds, func() { db.Close() }, nil
+
+	case "bolt":
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ds, err := boltdb.NewDataStore(db)
+		if err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return ds, func() { db.Close() }, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown datastore %q", name)
+}
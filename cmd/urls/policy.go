@@ -0,0 +1,72 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/icub3d/urls"
+)
+
+// filePolicy is a urls.DestinationPolicy that loads a DomainPolicy from
+// a file and loads it again whenever we get a SIGHUP.
+type filePolicy struct {
+	path string
+
+	mu     sync.RWMutex
+	policy *urls.DomainPolicy
+}
+
+// newFilePolicy loads the policy in the given file and starts watching
+// for SIGHUP.
+func newFilePolicy(path string) (*filePolicy, error) {
+	fp := &filePolicy{path: path}
+	if err := fp.load(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGHUP)
+		for range sig {
+			if err := fp.load(); err != nil {
+				log.Printf("reloading %v failed, keeping the old policy: %v",
+					path, err)
+			} else {
+				log.Printf("reloaded %v", path)
+			}
+		}
+	}()
+
+	return fp, nil
+}
+
+// load is a helper function that replaces the policy with the one in
+// the file.
+func (fp *filePolicy) load() error {
+	p, err := urls.LoadDomainPolicy(fp.path)
+	if err != nil {
+		return err
+	}
+
+	fp.mu.Lock()
+	fp.policy = p
+	fp.mu.Unlock()
+
+	return nil
+}
+
+// Allowed implements the urls.DestinationPolicy interface.
+func (fp *filePolicy) Allowed(long *url.URL) bool {
+	fp.mu.RLock()
+	defer fp.mu.RUnlock()
+
+	return fp.policy.Allowed(long)
+}
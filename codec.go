@@ -0,0 +1,262 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	// ErrInvalidID is returned when decoding an id that is empty or
+	// contains characters that aren't in the alphabet.
+	ErrInvalidID = errors.New("invalid id")
+
+	// ErrOverflow is returned when decoding an id that is too large
+	// for an int64.
+	ErrOverflow = errors.New("id overflows int64")
+)
+
+var (
+	// Base62 uses the characters 0-9, A-Z and a-z.
+	Base62 = MustCodec(
+		"0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz")
+
+	// Base58 is Base62 without the characters that are easy to confuse
+	// for one another (0, O, I and l).
+	Base58 = MustCodec(
+		"123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+
+	// Base36 uses the characters 0-9 and a-z. Since it only has one
+	// case, ids are case insensitive.
+	Base36 = MustCodec("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	// DefaultCodec is the codec used by IntToShort, ShortToInt, ValidID
+	// and the handlers of servers without their own (see WithCodec).
+	// Changing it changes which short ids are valid and what DataStores
+	// create, so it should only be set once when your program starts
+	// and never changed for an existing datastore.
+	DefaultCodec = Base62
+)
+
+// WithCodec sets the codec the server uses to normalize and check
+// short ids. It defaults to DefaultCodec. DataStores create ids with
+// DefaultCodec, so a server with another codec also needs an
+// IDGenerator that uses it (see SequentialIDs). A nil codec is
+// ignored.
+func WithCodec(c *Codec) Option {
+	return func(s *Server) {
+		if c != nil {
+			s.codec = c
+		}
+	}
+}
+
+// idCodec is a helper function that returns the server's codec or
+// DefaultCodec if it doesn't have one.
+func (s *Server) idCodec() *Codec {
+	if s.codec == nil {
+		return DefaultCodec
+	}
+	return s.codec
+}
+
+// Codec converts between integers and short ids using an alphabet.
+// The first character of the alphabet is zero, the second one and so
+// on. If none of the letters in the alphabet have both an upper and
+// lower case version, the codec is case insensitive.
+type Codec struct {
+	alphabet string
+
+	// index maps each byte to its value plus one. Zero means the byte
+	// isn't in the alphabet.
+	index [256]int64
+
+	// fold is true if the codec is case insensitive.
+	fold bool
+}
+
+// NewCodec creates a codec for the given alphabet. The alphabet must
+// have at least two characters, none of them repeated, and they must
+// all be safe to put in a URL path without escaping (0-9, A-Z, a-z,
+// '-', '.', '_' and '~').
+func NewCodec(alphabet string) (*Codec, error) {
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("alphabet %q is too short", alphabet)
+	}
+
+	c := &Codec{alphabet: alphabet}
+	upper, lower := false, false
+	for x := 0; x < len(alphabet); x++ {
+		b := alphabet[x]
+		if !unreserved(b) {
+			return nil, fmt.Errorf("alphabet %q contains invalid character %q",
+				alphabet, b)
+		}
+
+		if c.index[b] != 0 {
+			return nil, fmt.Errorf("alphabet %q repeats character %q",
+				alphabet, b)
+		}
+		c.index[b] = int64(x) + 1
+
+		upper = upper || (b >= 'A' && b <= 'Z')
+		lower = lower || (b >= 'a' && b <= 'z')
+	}
+
+	// If there is only one case, accept the other as well.
+	if upper != lower {
+		c.fold = true
+		for x := 0; x < len(alphabet); x++ {
+			b := alphabet[x]
+			if o := otherCase(b); o != b {
+				c.index[o] = c.index[b]
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// MustCodec is like NewCodec but panics if the alphabet is invalid.
+func MustCodec(alphabet string) *Codec {
+	c, err := NewCodec(alphabet)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// unreserved returns true if the given character can appear in a URL
+// path without escaping (RFC 3986).
+func unreserved(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'A' && b <= 'Z') ||
+		(b >= 'a' && b <= 'z') || b == '-' || b == '.' || b == '_' || b == '~'
+}
+
+// otherCase returns the upper case version of a lower case letter and
+// vice versa. Other characters are returned unchanged.
+func otherCase(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	} else if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
+
+// Alphabet returns the alphabet of the codec.
+func (c *Codec) Alphabet() string {
+	return c.alphabet
+}
+
+// Base returns the number of characters in the alphabet.
+func (c *Codec) Base() int64 {
+	return int64(len(c.alphabet))
+}
+
+// Valid returns true if the given string is a valid id. It must not
+// be empty and every character must be in the alphabet.
+func (c *Codec) Valid(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	for x := 0; x < len(id); x++ {
+		if c.index[id[x]] == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Normalize returns the id in the case used by the alphabet. Ids from
+// case sensitive codecs are returned unchanged.
+func (c *Codec) Normalize(id string) string {
+	if !c.fold {
+		return id
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r < 256 && c.index[r] != 0 {
+			return rune(c.alphabet[c.index[r]-1])
+		}
+		return r
+	}, id)
+}
+
+// Encode returns the string representation of the given integer.
+// Values less than 0 return the zero character.
+func (c *Codec) Encode(i int64) string {
+	if i <= 0 {
+		return c.alphabet[:1]
+	}
+
+	base := c.Base()
+	var buf [64]byte
+	x := len(buf)
+	for i > 0 {
+		x--
+		buf[x] = c.alphabet[i%base]
+		i = i / base
+	}
+
+	return string(buf[x:])
+}
+
+// Decode returns the integer representation of the given short id.
+// Invalid ids and those too large for an int64 return 0. Use Parse
+// if you need to know why.
+func (c *Codec) Decode(s string) int64 {
+	i, _ := c.Parse(s)
+	return i
+}
+
+// Parse returns the integer representation of the given short id. It
+// returns ErrInvalidID if the id isn't valid and ErrOverflow if it's
+// too large for an int64. Every value from Encode for i >= 0 parses
+// back to i.
+func (c *Codec) Parse(s string) (int64, error) {
+	if !c.Valid(s) {
+		return 0, ErrInvalidID
+	}
+
+	base := c.Base()
+
+	var sum int64
+	for x := 0; x < len(s); x++ {
+		v := c.value(s[x])
+
+		// sum*base + v must not be larger than the max.
+		if sum > (math.MaxInt64-v)/base {
+			return 0, ErrOverflow
+		}
+
+		sum = sum*base + v
+	}
+
+	return sum, nil
+}
+
+// value returns the value of the given character or zero if it's not
+// in the alphabet.
+func (c *Codec) value(b byte) int64 {
+	if v := c.index[b]; v != 0 {
+		return v - 1
+	}
+	return 0
+}
+
+// digit returns the character for the given value or an empty string
+// if it's out of range.
+func (c *Codec) digit(i int64) string {
+	if i < 0 || i >= c.Base() {
+		return ""
+	}
+	return c.alphabet[i : i+1]
+}
@@ -0,0 +1,160 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestNewCodec(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		valid    bool
+	}{
+		{alphabet: "01", valid: true},
+		{alphabet: "0123456789abcdefghijklmnopqrstuvwxyz-_", valid: true},
+		{alphabet: "0", valid: false},
+		{alphabet: "0120", valid: false},
+		{alphabet: "01/", valid: false},
+		{alphabet: "01é", valid: false},
+	}
+
+	for k, test := range tests {
+		_, err := NewCodec(test.alphabet)
+		if (err == nil) != test.valid {
+			t.Errorf("Test %v: NewCodec(%q) expected valid %v, got %v",
+				k, test.alphabet, test.valid, err)
+		}
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	tests := []struct {
+		codec   *Codec
+		i       int64
+		encoded string
+	}{
+		{codec: Base62, i: 0, encoded: "0"},
+		{codec: Base62, i: 25883599, encoded: "1kbVP"},
+		{codec: Base58, i: 0, encoded: "1"},
+		{codec: Base58, i: 57, encoded: "z"},
+		{codec: Base58, i: 58, encoded: "21"},
+		{codec: Base36, i: 35, encoded: "z"},
+		{codec: Base36, i: 36, encoded: "10"},
+		{codec: Base36, i: 1295, encoded: "zz"},
+	}
+
+	for k, test := range tests {
+		e := test.codec.Encode(test.i)
+		if e != test.encoded {
+			t.Errorf("Test %v: expected Encode(%v) = %v, got %v",
+				k, test.i, test.encoded, e)
+		}
+
+		d := test.codec.Decode(test.encoded)
+		if d != test.i {
+			t.Errorf("Test %v: expected Decode(%v) = %v, got %v",
+				k, test.encoded, test.i, d)
+		}
+	}
+}
+
+func TestCodecValid(t *testing.T) {
+	tests := []struct {
+		codec    *Codec
+		id       string
+		valid    bool
+		expected string
+	}{
+		// Base58 doesn't have the look-alikes.
+		{codec: Base58, id: "abc", valid: true, expected: "abc"},
+		{codec: Base58, id: "0", valid: false, expected: "0"},
+		{codec: Base58, id: "O", valid: false, expected: "O"},
+		{codec: Base58, id: "l", valid: false, expected: "l"},
+		{codec: Base58, id: "I", valid: false, expected: "I"},
+
+		// Base36 is case insensitive.
+		{codec: Base36, id: "abc", valid: true, expected: "abc"},
+		{codec: Base36, id: "ABC", valid: true, expected: "abc"},
+		{codec: Base36, id: "aB-", valid: false, expected: "ab-"},
+
+		// Base62 isn't.
+		{codec: Base62, id: "ABC", valid: true, expected: "ABC"},
+		{codec: Base62, id: "", valid: false, expected: ""},
+	}
+
+	for k, test := range tests {
+		if v := test.codec.Valid(test.id); v != test.valid {
+			t.Errorf("Test %v: expected Valid(%v) = %v, got %v",
+				k, test.id, test.valid, v)
+		}
+
+		if n := test.codec.Normalize(test.id); n != test.expected {
+			t.Errorf("Test %v: expected Normalize(%v) = %v, got %v",
+				k, test.id, test.expected, n)
+		}
+	}
+
+	if Base36.Decode("ZZ") != Base36.Decode("zz") {
+		t.Errorf("expected Base36 to decode case insensitively")
+	}
+}
+
+func TestCodecParse(t *testing.T) {
+	tests := []struct {
+		codec    *Codec
+		id       string
+		expected int64
+		err      error
+	}{
+		{codec: Base62, id: "0", expected: 0},
+		{codec: Base62, id: "1kbVP", expected: 25883599},
+		{codec: Base62, id: "AzL8n0Y58m7", expected: math.MaxInt64},
+		{codec: Base62, id: "AzL8n0Y58m8", err: ErrOverflow},
+		{codec: Base62, id: "zzzzzzzzzzz", err: ErrOverflow},
+		{codec: Base62, id: "zzzzzzzzzzzzzzzzzzzzzz", err: ErrOverflow},
+		{codec: Base62, id: "00000000000000000000001", expected: 1},
+		{codec: Base62, id: "", err: ErrInvalidID},
+		{codec: Base62, id: "a-b", err: ErrInvalidID},
+		{codec: Base58, id: "0", err: ErrInvalidID},
+		{codec: Base36, id: "1Y2P0IJ32E8E7", expected: math.MaxInt64},
+		{codec: Base36, id: "1Y2P0IJ32E8E8", err: ErrOverflow},
+	}
+
+	for k, test := range tests {
+		i, err := test.codec.Parse(test.id)
+		if err != test.err {
+			t.Errorf("Test %v: expected Parse(%v) error %v, got %v",
+				k, test.id, test.err, err)
+		}
+		if i != test.expected {
+			t.Errorf("Test %v: expected Parse(%v) = %v, got %v",
+				k, test.id, test.expected, i)
+		}
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	codecs := []*Codec{Base62, Base58, Base36, MustCodec("01")}
+
+	// The edges of the range and some random values in between.
+	values := []int64{0, 1, math.MaxInt64 - 1, math.MaxInt64}
+	r := rand.New(rand.NewSource(1))
+	for x := 0; x < 1000; x++ {
+		values = append(values, r.Int63())
+	}
+
+	for k, c := range codecs {
+		for _, i := range values {
+			id := c.Encode(i)
+			d, err := c.Parse(id)
+			if err != nil || d != i {
+				t.Errorf("Test %v: Parse(Encode(%v)) = %v, %v", k, i, d, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"net/http"
+)
+
+// WithComingSoon sets the handler used to respond to urls that aren't
+// active yet (see URL.ActiveFrom). To send people somewhere else
+// instead, use something like:
+//
+//	WithComingSoon(http.RedirectHandler("https://example.com/soon",
+//		http.StatusFound))
+//
+// It defaults to a 404 Not Found with a page saying the link isn't
+// active yet.
+func WithComingSoon(h http.Handler) Option {
+	return func(s *Server) {
+		s.comingSoon = h
+	}
+}
+
+// comingSoonPage is written for urls that aren't active yet if the
+// server doesn't have a coming soon handler.
+const comingSoonPage = `<!DOCTYPE html>
+<html>
+<head><title>Coming soon</title></head>
+<body>
+<h1>Coming soon</h1>
+<p>This link isn't active yet. Please try again later.</p>
+</body>
+</html>
+`
+
+// writeComingSoon is a helper function that responds to a url that
+// isn't active yet. The response isn't cached so it stops as soon as
+// the url is active.
+func (s *Server) writeComingSoon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if s.comingSoon != nil {
+		s.comingSoon.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(comingSoonPage))
+}
@@ -0,0 +1,76 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirectComingSoon(t *testing.T) {
+	ds := prep()
+	now := time.Now()
+	ds.PutURL(&URL{Short: "soon", Long: "http://example.com/secret",
+		ActiveFrom: now.Add(time.Hour)})
+	ds.PutURL(&URL{Short: "live", Long: "http://example.com/launched",
+		ActiveFrom: now.Add(-time.Hour)})
+
+	fallback := WithComingSoon(http.RedirectHandler(
+		"http://example.com/teaser", http.StatusFound))
+
+	tests := []struct {
+		opts     []Option
+		id       string
+		code     int
+		location string
+		body     string
+	}{
+		{id: "soon", code: http.StatusNotFound, body: "Coming soon"},
+		{opts: []Option{fallback}, id: "soon", code: http.StatusFound,
+			location: "http://example.com/teaser"},
+		{id: "live", code: http.StatusFound,
+			location: "http://example.com/launched"},
+		{opts: []Option{fallback}, id: "live", code: http.StatusFound,
+			location: "http://example.com/launched"},
+	}
+
+	for k, test := range tests {
+		s := NewServer(ds, test.opts...)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://localhost/"+test.id, nil)
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("Test %v: expected Location %q, got %q",
+				k, test.location, loc)
+		}
+
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("Test %v: expected body to contain %v, got %v",
+				k, test.body, w.Body.String())
+		}
+
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("Test %v: the destination leaked: %v",
+				k, w.Body.String())
+		}
+	}
+
+	// Only the live ones should have been logged.
+	if c, _ := ds.CountLogs("soon"); c != 0 {
+		t.Errorf("expected no clicks logged before it's active, got %v", c)
+	}
+	if c, _ := ds.CountLogs("live"); c != 2 {
+		t.Errorf("expected 2 clicks logged after it's active, got %v", c)
+	}
+}
@@ -14,6 +14,14 @@ var (
 	// see this value and will handle a request differently if it gets
 	// it as opposed to another error.
 	ErrNotFound = errors.New("not found")
+
+	// ErrExists is returned by a URLCreator when the short id it was
+	// asked to use is already taken.
+	ErrExists = errors.New("already exists")
+
+	// ErrVersion is returned by a URLUpdater when the url has changed
+	// since the version it was asked to update.
+	ErrVersion = errors.New("version mismatch")
 )
 
 // DataStore is the interface that any backend datastore should
@@ -36,11 +44,13 @@ type DataStore interface {
 	// overwrite it. Otherwise insert a new entry. When inserting, the
 	// new short ID should be updated before insertion and it should be
 	// returned. You can use the helper functions IntToShort to help
-	// convert a unique integer to a representative string.
+	// convert a unique integer to a representative string. The new
+	// short ID must not already be in use, since users can pick their
+	// own (see URLCreator).
 	PutURL(url *URL) (string, error)
 
-	// Get the statistics for the given short id. If one isn't found, a
-	// blank one should be returned.
+	// Get the statistics for the given short id. If there aren't any,
+	// ErrNotFound should be returned.
 	GetStatistics(short string) (*Statistics, error)
 
 	// Put the given statistics into the data store. If the short id
@@ -57,3 +67,118 @@ type DataStore interface {
 	// date (oldest first) and offset by the given offset.
 	GetLogs(short string, limit, offset int) ([]*Log, error)
 }
+
+// URLCreator is an optional interface a DataStore can implement to
+// insert a url with the short id it already has in a single step. If
+// the short id is already in use, ErrExists should be returned and
+// nothing should change. If the DataStore can't store the short id,
+// ErrInvalidID should be returned. DataStores that don't implement it
+// are checked with GetURL before calling PutURL, which can race.
+type URLCreator interface {
+	CreateURL(url *URL) error
+}
+
+// URLFinder is an optional interface a DataStore can implement to
+// find a url by its long url. It's used to reuse existing urls instead
+// of creating duplicates (see WithDeduplication). If no url has the
+// long url, ErrNotFound should be returned. If more than one does,
+// any of them can be returned.
+type URLFinder interface {
+	FindURL(long string) (*URL, error)
+}
+
+// URLUpdater is an optional interface a DataStore can implement to
+// update a url only if nobody else has since it was read. If the
+// stored url's Version isn't version, ErrVersion should be returned
+// and nothing should change. Otherwise everything but the Short,
+// Created and Clicks should be replaced with the fields of u and the
+// Version set to version+1. u should then be updated to match what's
+// stored. ErrNotFound should be returned if there is no url with u's
+// short id.
+//
+// DataStores that don't implement it are checked with GetURL before
+// calling PutURL, which can race.
+type URLUpdater interface {
+	UpdateURL(u *URL, version int) error
+}
+
+// ClickCounter is an optional interface a DataStore can implement to
+// add one to the Clicks of a url without touching the rest of it.
+// DataStores that don't implement it have the whole url put back with
+// PutURL, which can undo changes made to it at the same time.
+type ClickCounter interface {
+	AddClick(short string) error
+}
+
+// StatisticsCounter is an optional interface a DataStore can implement
+// to add to the statistics of a url in a single step. The counts in
+// stats should be added to the stored ones as Statistics.Add does,
+// creating them if there aren't any. DataStores that don't implement
+// it have the statistics read with GetStatistics and put back with
+// PutStatistics, which loses counts when urls are clicked at the same
+// time.
+type StatisticsCounter interface {
+	AddStatistics(stats *Statistics) error
+}
+
+// createURL is a helper function that inserts the given url with its
+// short id, returning ErrExists if it's taken.
+func createURL(ds DataStore, url *URL) error {
+	if c, ok := ds.(URLCreator); ok {
+		return c.CreateURL(url)
+	}
+
+	// Some datastores return nil instead of ErrNotFound.
+	u, err := ds.GetURL(url.Short)
+	if err != nil && err != ErrNotFound {
+		return err
+	} else if err == nil && u != nil {
+		return ErrExists
+	}
+
+	_, err = ds.PutURL(url)
+	return err
+}
+
+// updateURL is a helper function that updates the given url if it's
+// still at the given version.
+func updateURL(ds DataStore, u *URL, version int) error {
+	if up, ok := ds.(URLUpdater); ok {
+		return up.UpdateURL(u, version)
+	}
+
+	// Some datastores return nil instead of ErrNotFound.
+	old, err := ds.GetURL(u.Short)
+	if err == nil && old == nil {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	} else if old.Version != version {
+		return ErrVersion
+	}
+
+	u.Created = old.Created
+	u.Clicks = old.Clicks
+	u.Version = version + 1
+	_, err = ds.PutURL(u)
+	return err
+}
+
+// addStatistics is a helper function that adds the counts in stats to
+// the stored statistics of the url.
+func addStatistics(ds DataStore, stats *Statistics) error {
+	if c, ok := ds.(StatisticsCounter); ok {
+		return c.AddStatistics(stats)
+	}
+
+	// Some datastores return nil instead of ErrNotFound.
+	old, err := ds.GetStatistics(stats.Short)
+	if err == ErrNotFound || (err == nil && old == nil) {
+		old = NewStatistics(stats.Short)
+	} else if err != nil {
+		return err
+	}
+
+	old.Add(stats)
+	return ds.PutStatistics(old)
+}
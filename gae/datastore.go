@@ -9,6 +9,7 @@ import (
 	"appengine/datastore"
 	"appengine/memcache"
 	"encoding/json"
+	"errors"
 	"github.com/icub3d/urls"
 )
 
@@ -23,6 +24,11 @@ const (
 	statsKind = "Stats"
 )
 
+// errNotCanonical is returned for short ids that can't be used as
+// keys. Keys are integers, so "01" and "1" would be the same key and
+// "0" would be an incomplete key.
+var errNotCanonical = errors.New("short id can't be used as a key")
+
 // DataStore implements the urls.DataStore interface
 type DataStore struct {
 	cxt appengine.Context
@@ -35,6 +41,20 @@ func NewDataStore(cxt appengine.Context) *DataStore {
 	}
 }
 
+// key is a helper function that returns the key of the given kind
+// for the short id.
+func (ds *DataStore) key(kind, id string) (*datastore.Key, error) {
+	i, err := urls.ParseShort(id)
+	if err != nil {
+		return nil, err
+	}
+	if i == 0 || urls.IntToShort(i) != id {
+		return nil, errNotCanonical
+	}
+
+	return datastore.NewKey(ds.cxt, kind, "", i, nil), nil
+}
+
 // CountURLs implements the urls.DataStore interface.
 func (ds *DataStore) CountURLs() (int, error) {
 	q := datastore.NewQuery(urlKind)
@@ -57,9 +77,13 @@ func (ds *DataStore) GetURL(id string) (*urls.URL, error) {
 
 	if item, err := memcache.Get(ds.cxt, id); err != nil {
 		// When we don't find one, we should get it from the datastore.
-		key := datastore.NewKey(ds.cxt, urlKind, "", urls.ShortToInt(id), nil)
+		key, err := ds.key(urlKind, id)
+		if err != nil {
+			// It couldn't have been stored.
+			return nil, urls.ErrNotFound
+		}
 
-		err := datastore.Get(ds.cxt, key, &u)
+		err = datastore.Get(ds.cxt, key, &u)
 		if err == datastore.ErrNoSuchEntity {
 			return nil, urls.ErrNotFound
 		} else if err != nil {
@@ -94,7 +118,10 @@ func (ds *DataStore) GetURL(id string) (*urls.URL, error) {
 
 // DeleteURL implements the urls.DataStore interface.
 func (ds *DataStore) DeleteURL(id string) error {
-	key := datastore.NewKey(ds.cxt, urlKind, "", urls.ShortToInt(id), nil)
+	key, err := ds.key(urlKind, id)
+	if err != nil {
+		return urls.ErrNotFound
+	}
 
 	// Delete the logs.
 	iter := datastore.NewQuery(logKind).Ancestor(key).Run(ds.cxt)
@@ -104,36 +131,166 @@ func (ds *DataStore) DeleteURL(id string) error {
 	}
 
 	// Delete the stats.
-	skey := datastore.NewKey(ds.cxt, statsKind, "", urls.ShortToInt(id), nil)
+	skey := datastore.NewKey(ds.cxt, statsKind, "", key.IntID(), nil)
 	datastore.Delete(ds.cxt, skey)
 
-	return datastore.Delete(ds.cxt, key)
+	if err := datastore.Delete(ds.cxt, key); err != nil {
+		return err
+	}
+
+	ds.uncache(id)
+	return nil
+}
+
+// uncache is a helper function that removes the url from memcache
+// after it's changed so GetURL doesn't return the old one.
+func (ds *DataStore) uncache(id string) {
+	err := memcache.Delete(ds.cxt, id)
+	if err != nil && err != memcache.ErrCacheMiss {
+		ds.cxt.Errorf("failed to delete %v from memcache: %v", id, err)
+	}
 }
 
 // PutURL implements the urls.DataStore interface.
 func (ds *DataStore) PutURL(u *urls.URL) (string, error) {
 
-	// We may need to create an ID.
-	if u.Short == "" {
+	// We may need to create an ID. Skip any that were taken by an
+	// alias.
+	for u.Short == "" {
 		i, _, err := datastore.AllocateIDs(ds.cxt, urlKind, nil, 1)
 		if err != nil {
 			return "", err
 		}
 
-		u.Short = urls.IntToShort(i)
+		key := datastore.NewKey(ds.cxt, urlKind, "", i, nil)
+		err = datastore.Get(ds.cxt, key, &urls.URL{})
+		if err == datastore.ErrNoSuchEntity {
+			u.Short = urls.IntToShort(i)
+		} else if err != nil {
+			return "", err
+		}
 	}
 
 	// Get the key
-	key := datastore.NewKey(ds.cxt, urlKind, "", urls.ShortToInt(u.Short), nil)
+	key, err := ds.key(urlKind, u.Short)
+	if err != nil {
+		return "", err
+	}
 
-	_, err := datastore.Put(ds.cxt, key, u)
+	_, err = datastore.Put(ds.cxt, key, u)
 	if err != nil {
 		return "", err
 	}
 
+	ds.uncache(u.Short)
 	return u.Short, nil
 }
 
+// CreateURL implements the urls.URLCreator interface. Aliases that
+// can't be used as keys (see errNotCanonical) or that are too large
+// for one return urls.ErrInvalidID.
+func (ds *DataStore) CreateURL(u *urls.URL) error {
+	key, err := ds.key(urlKind, u.Short)
+	if err != nil {
+		return urls.ErrInvalidID
+	}
+
+	return datastore.RunInTransaction(ds.cxt, func(cxt appengine.Context) error {
+		err := datastore.Get(cxt, key, &urls.URL{})
+		if err == nil {
+			return urls.ErrExists
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err = datastore.Put(cxt, key, u)
+		return err
+	}, nil)
+}
+
+// UpdateURL implements the urls.URLUpdater interface.
+func (ds *DataStore) UpdateURL(u *urls.URL, version int) error {
+	key, err := ds.key(urlKind, u.Short)
+	if err != nil {
+		return urls.ErrNotFound
+	}
+
+	c := *u
+	err = datastore.RunInTransaction(ds.cxt, func(cxt appengine.Context) error {
+		var old urls.URL
+		err := datastore.Get(cxt, key, &old)
+		if err == datastore.ErrNoSuchEntity {
+			return urls.ErrNotFound
+		} else if err != nil {
+			return err
+		} else if old.Version != version {
+			return urls.ErrVersion
+		}
+
+		c.Created = old.Created
+		c.Clicks = old.Clicks
+		c.Version = version + 1
+
+		_, err = datastore.Put(cxt, key, &c)
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	ds.uncache(u.Short)
+	*u = c
+	return nil
+}
+
+// AddClick implements the urls.ClickCounter interface.
+func (ds *DataStore) AddClick(id string) error {
+	key, err := ds.key(urlKind, id)
+	if err != nil {
+		return urls.ErrNotFound
+	}
+
+	err = datastore.RunInTransaction(ds.cxt, func(cxt appengine.Context) error {
+		var u urls.URL
+		err := datastore.Get(cxt, key, &u)
+		if err == datastore.ErrNoSuchEntity {
+			return urls.ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		u.Clicks++
+		_, err = datastore.Put(cxt, key, &u)
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	ds.uncache(id)
+	return nil
+}
+
+// FindURL implements the urls.URLFinder interface.
+func (ds *DataStore) FindURL(long string) (*urls.URL, error) {
+	q := datastore.NewQuery(urlKind).Filter("Long =", long).Limit(1)
+
+	var us []*urls.URL
+	if _, err := q.GetAll(ds.cxt, &us); err != nil {
+		return nil, err
+	} else if len(us) == 0 {
+		return nil, urls.ErrNotFound
+	}
+
+	return us[0], nil
+}
+
+// NextID implements the urls.Sequencer interface.
+func (ds *DataStore) NextID() (int64, error) {
+	i, _, err := datastore.AllocateIDs(ds.cxt, urlKind, nil, 1)
+	return i, err
+}
+
 // I guess these things need to be stored as a struct.
 type statData struct {
 	Data []byte
@@ -145,8 +302,12 @@ func (ds *DataStore) GetStatistics(id string) (*urls.Statistics, error) {
 
 	s := statData{Data: []byte{}}
 
-	key := datastore.NewKey(ds.cxt, statsKind, "", urls.ShortToInt(id), nil)
-	err := datastore.Get(ds.cxt, key, &s)
+	key, err := ds.key(statsKind, id)
+	if err != nil {
+		return nil, urls.ErrNotFound
+	}
+
+	err = datastore.Get(ds.cxt, key, &s)
 	if err == datastore.ErrNoSuchEntity {
 		return nil, urls.ErrNotFound
 	} else if err != nil {
@@ -170,8 +331,10 @@ func (ds *DataStore) PutStatistics(stats *urls.Statistics) error {
 		return err
 	}
 
-	key := datastore.NewKey(ds.cxt, statsKind, "",
-		urls.ShortToInt(stats.Short), nil)
+	key, err := ds.key(statsKind, stats.Short)
+	if err != nil {
+		return err
+	}
 
 	s := statData{Data: data}
 	_, err = datastore.Put(ds.cxt, key, &s)
@@ -179,20 +342,57 @@ func (ds *DataStore) PutStatistics(stats *urls.Statistics) error {
 	return err
 }
 
+// AddStatistics implements the urls.StatisticsCounter interface.
+func (ds *DataStore) AddStatistics(stats *urls.Statistics) error {
+	key, err := ds.key(statsKind, stats.Short)
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(ds.cxt, func(cxt appengine.Context) error {
+		old := urls.NewStatistics(stats.Short)
+
+		var s statData
+		err := datastore.Get(cxt, key, &s)
+		if err == nil {
+			err = json.Unmarshal(s.Data, old)
+		} else if err == datastore.ErrNoSuchEntity {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		old.Add(stats)
+		s.Data, err = json.Marshal(old)
+		if err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(cxt, key, &s)
+		return err
+	}, nil)
+}
+
 // LogClick implements the urls.DataStore interface.
 func (ds *DataStore) LogClick(l *urls.Log) error {
-	pkey := datastore.NewKey(ds.cxt, urlKind, "",
-		urls.ShortToInt(l.Short), nil)
+	pkey, err := ds.key(urlKind, l.Short)
+	if err != nil {
+		return err
+	}
 	key := datastore.NewIncompleteKey(ds.cxt, logKind, pkey)
 
-	_, err := datastore.Put(ds.cxt, key, l)
+	_, err = datastore.Put(ds.cxt, key, l)
 
 	return err
 }
 
 // CountLogs implements the urls.DataStore interface.
 func (ds *DataStore) CountLogs(id string) (int, error) {
-	pkey := datastore.NewKey(ds.cxt, urlKind, "", urls.ShortToInt(id), nil)
+	pkey, err := ds.key(urlKind, id)
+	if err != nil {
+		return 0, nil
+	}
 
 	q := datastore.NewQuery(logKind).Ancestor(pkey)
 	return q.Count(ds.cxt)
@@ -202,12 +402,15 @@ func (ds *DataStore) CountLogs(id string) (int, error) {
 func (ds *DataStore) GetLogs(id string, limit, offset int) ([]*urls.Log,
 	error) {
 
-	pkey := datastore.NewKey(ds.cxt, urlKind, "", urls.ShortToInt(id), nil)
+	pkey, err := ds.key(urlKind, id)
+	if err != nil {
+		return []*urls.Log{}, nil
+	}
 
-	q := datastore.NewQuery(logKind).Ancestor(pkey).Order("Created").
+	q := datastore.NewQuery(logKind).Ancestor(pkey).Order("When").
 		Offset(offset).Limit(limit)
 
 	us := make([]*urls.Log, 0, limit)
-	_, err := q.GetAll(ds.cxt, &us)
+	_, err = q.GetAll(ds.cxt, &us)
 	return us, err
 }
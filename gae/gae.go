@@ -38,11 +38,12 @@ func userHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // getOrNotFound is a helper function that returns a handle function
-// that accepts GET request with the given handler or a not found.
+// that accepts GET and HEAD requests with the given handler or a not
+// found.
 func getOrNotFound(f urls.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ds := NewDataStore(appengine.NewContext(r))
-		if r.Method == "GET" {
+		if r.Method == "GET" || r.Method == "HEAD" {
 			f(ds, w, r)
 		} else {
 			w.WriteHeader(http.StatusNotFound)
@@ -54,7 +55,7 @@ func getOrNotFound(f urls.HandlerFunc) http.HandlerFunc {
 // urlsHandler handles the GET/POST for /admin/urls
 func urlsHandler(w http.ResponseWriter, r *http.Request) {
 	ds := NewDataStore(appengine.NewContext(r))
-	if r.Method == "GET" {
+	if r.Method == "GET" || r.Method == "HEAD" {
 		urls.GetURLs(ds, w, r)
 	} else if r.Method == "POST" {
 		urls.NewURL(ds, w, r)
@@ -68,9 +69,14 @@ func urlsHandler(w http.ResponseWriter, r *http.Request) {
 func urlHandler(w http.ResponseWriter, r *http.Request) {
 	ds := NewDataStore(appengine.NewContext(r))
 
-	if r.Method == "DELETE" {
+	switch r.Method {
+	case "GET", "HEAD":
+		urls.GetURL(ds, w, r)
+	case "PUT", "PATCH":
+		urls.UpdateURL(ds, w, r)
+	case "DELETE":
 		urls.DeleteURL(ds, w, r)
-	} else {
+	default:
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("not found"))
 	}
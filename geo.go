@@ -0,0 +1,355 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoResolver finds the country clicks come from for the statistics.
+type GeoResolver interface {
+	// Country returns the ISO 3166 code of the country the address is
+	// in or an empty string if it isn't known.
+	Country(ip net.IP) string
+}
+
+// WithGeoResolver uses the given GeoResolver to find the country of
+// each click. Without one, every click's country is Unknown.
+func WithGeoResolver(g GeoResolver) Option {
+	return func(s *Server) {
+		s.geo = g
+	}
+}
+
+// errGeoLite2CSV is returned for the lines of GeoLite2 CSV files.
+var errGeoLite2CSV = errors.New("GeoLite2 CSV files aren't supported, " +
+	"use the MaxMind DB (.mmdb) file instead")
+
+// mmdbMarker starts the metadata at the end of a MaxMind DB file.
+var mmdbMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// GeoDB is a GeoResolver that reads countries from a file. It can be
+// a MaxMind DB file (like GeoLite2-Country.mmdb) or a CSV file where
+// each line is a network and a country:
+//
+//	1.0.0.0/24,AU
+//	2001:db8::/32,NL
+//
+// or the first and last address of a range and a country:
+//
+//	1.0.0.0,1.0.0.255,AU
+//
+// IPv4 and IPv6 addresses both work and IPv4-mapped IPv6 addresses
+// (like ::ffff:1.0.0.1) are treated as the IPv4 address they contain.
+//
+// The CSV files from the old GeoIP Country database (with the range
+// as numbers and the country name after the code) work as well, but
+// the GeoLite2 CSV files don't since their countries are in a separate
+// file. Use the GeoLite2 MaxMind DB file instead. A header line and
+// lines starting with # are ignored. The ranges include their first
+// and last addresses and can't overlap.
+//
+// It's safe for concurrent use, so the file can be reloaded while
+// it's being used.
+type GeoDB struct {
+	path string
+
+	mu  sync.RWMutex
+	r   GeoResolver
+	mod time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// OpenGeoDB reads the file at the given path. It can be a MaxMind DB
+// file or a CSV file of network,country or start,end,country lines
+// (see GeoDB for the details). GeoLite2 CSV files aren't supported.
+func OpenGeoDB(path string) (*GeoDB, error) {
+	g := &GeoDB{
+		path: path,
+		stop: make(chan struct{}),
+	}
+
+	if err := g.Reload(); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// Country implements the GeoResolver interface.
+func (g *GeoDB) Country(ip net.IP) string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.r.Country(ip)
+}
+
+// Reload reads the file again. If it can't be read, the error is
+// returned and the old data is kept.
+func (g *GeoDB) Reload() error {
+	fi, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+
+	var r GeoResolver
+	if bytes.Contains(data, mmdbMarker) {
+		r, err = newMMDB(data)
+	} else {
+		r, err = readRanges(bytes.NewReader(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %v", g.path, err)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.r = r
+	g.mod = fi.ModTime()
+	return nil
+}
+
+// Watch checks the file every interval and reloads it when its
+// modification time changes. Errors are logged and the old data is
+// kept. It returns when Close is called, so it's usually started in
+// its own goroutine.
+func (g *GeoDB) Watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-t.C:
+		}
+
+		fi, err := os.Stat(g.path)
+		if err != nil {
+			log.Printf("checking %v failed: %v", g.path, err)
+			continue
+		}
+
+		g.mu.RLock()
+		changed := !fi.ModTime().Equal(g.mod)
+		g.mu.RUnlock()
+
+		if changed {
+			if err := g.Reload(); err != nil {
+				log.Printf("reloading %v failed: %v", g.path, err)
+			} else {
+				log.Printf("reloaded %v", g.path)
+			}
+		}
+	}
+}
+
+// Close stops Watch.
+func (g *GeoDB) Close() error {
+	g.once.Do(func() {
+		close(g.stop)
+	})
+	return nil
+}
+
+// mmdb is a GeoResolver for a MaxMind DB file.
+type mmdb struct {
+	db *maxminddb.Reader
+}
+
+// newMMDB creates a GeoResolver for the given MaxMind DB file.
+func newMMDB(data []byte) (*mmdb, error) {
+	db, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mmdb{db: db}, nil
+}
+
+// Country implements the GeoResolver interface.
+func (m *mmdb) Country(ip net.IP) string {
+	var rec struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	// Look IPv4-mapped addresses up as IPv4 addresses.
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if err := m.db.Lookup(ip, &rec); err != nil {
+		return ""
+	}
+	return rec.Country.ISOCode
+}
+
+// ipRange is a range of addresses in a country.
+type ipRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+}
+
+// ipRanges is a GeoResolver for a list of ranges. They are sorted by
+// their start and don't overlap, so they can be binary searched.
+type ipRanges []ipRange
+
+// readRanges reads the ranges in the CSV format described by GeoDB.
+// Ranges that overlap return an error.
+func readRanges(r io.Reader) (ipRanges, error) {
+	var ranges ipRanges
+
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for n := 1; ; n++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		ipr, err := parseRange(rec)
+		if err != nil && err != errGeoLite2CSV && n == 1 {
+			// It's probably a header.
+			continue
+		} else if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+
+		ranges = append(ranges, ipr)
+	}
+
+	// IPv4 addresses sort before IPv6 ones, so they can share a list.
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Less(ranges[j].start)
+	})
+
+	for x := 1; x < len(ranges); x++ {
+		a, b := ranges[x-1], ranges[x]
+		if b.start.Compare(a.end) <= 0 {
+			return nil, fmt.Errorf("ranges %v-%v and %v-%v overlap",
+				a.start, a.end, b.start, b.end)
+		}
+	}
+
+	return ranges, nil
+}
+
+// parseRange is a helper function that parses a line of a CSV file.
+// IPv4-mapped IPv6 addresses are stored as IPv4 addresses.
+func parseRange(rec []string) (ipRange, error) {
+	var start, end string
+	var country string
+
+	// GeoLite2 has a network and ids that need another file to turn
+	// into countries.
+	if len(rec) > 2 && strings.Contains(rec[0], "/") {
+		return ipRange{}, errGeoLite2CSV
+	}
+
+	switch len(rec) {
+	case 2:
+		p, err := netip.ParsePrefix(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid network %q", rec[0])
+		}
+
+		p = p.Masked()
+		return ipRange{
+			start:   p.Addr().Unmap(),
+			end:     lastAddr(p).Unmap(),
+			country: strings.ToUpper(strings.TrimSpace(rec[1])),
+		}, nil
+	case 3:
+		start, end, country = rec[0], rec[1], rec[2]
+	case 6:
+		start, end, country = rec[0], rec[1], rec[4]
+	default:
+		return ipRange{}, fmt.Errorf("expected 2, 3 or 6 fields, got %v",
+			len(rec))
+	}
+
+	s, err := netip.ParseAddr(strings.TrimSpace(start))
+	if err != nil {
+		return ipRange{}, fmt.Errorf("invalid address %q", start)
+	}
+
+	e, err := netip.ParseAddr(strings.TrimSpace(end))
+	if err != nil {
+		return ipRange{}, fmt.Errorf("invalid address %q", end)
+	}
+
+	s, e = s.Unmap(), e.Unmap()
+	if s.BitLen() != e.BitLen() || s.Compare(e) > 0 {
+		return ipRange{}, fmt.Errorf("invalid range %v-%v", start, end)
+	}
+
+	return ipRange{
+		start:   s,
+		end:     e,
+		country: strings.ToUpper(strings.TrimSpace(country)),
+	}, nil
+}
+
+// lastAddr is a helper function that returns the last address in the
+// given prefix.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().AsSlice()
+	for x := p.Bits(); x < len(a)*8; x++ {
+		a[x/8] |= 0x80 >> (x % 8)
+	}
+
+	last, _ := netip.AddrFromSlice(a)
+	return last
+}
+
+// Country implements the GeoResolver interface.
+func (ranges ipRanges) Country(ip net.IP) string {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	// Find the first range that starts after the address. The one
+	// before it is the only one that can contain it.
+	x := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].start.Compare(addr) > 0
+	})
+	if x > 0 && addr.Compare(ranges[x-1].end) <= 0 {
+		return ranges[x-1].country
+	}
+
+	return ""
+}
@@ -0,0 +1,216 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRanges(t *testing.T) {
+	tests := []struct {
+		csv string
+		err string
+	}{
+		{csv: "1.0.0.0/24,AU\n"},
+		{csv: "network,country\n1.0.0.0/24,AU\n"},
+		{csv: "# comment\n1.0.0.0,1.0.0.255,AU\n"},
+		{csv: "1.0.0.0/24,AU\nnot a network,AU\n", err: "line 2"},
+		{csv: "1.0.0.0/24,AU\n1.0.0.0,AU,x,y\n", err: "expected 2, 3 or 6"},
+		{csv: "1.0.0.0/24,AU\n2001:db8::,2001:db8::ff,NL\n"},
+		{csv: "1.0.0.0/24,AU\n1.0.0.1,::2,AU\n", err: "invalid range"},
+		{csv: "1.0.0.0/24,AU\n1.0.0.2,1.0.0.1,AU\n", err: "invalid range"},
+		{csv: "1.0.0.0/16,AU\n1.0.255.255,1.1.0.0,AU\n", err: "overlap"},
+		{csv: "network,geoname_id,registered_country_geoname_id," +
+			"represented_country_geoname_id,is_anonymous_proxy," +
+			"is_satellite_provider\n1.0.0.0/24,2077456,2077456,,0,0\n",
+			err: "GeoLite2 CSV files aren't supported"},
+		{csv: "1.0.0.0/24,2077456,2077456,,0,0,0\n",
+			err: "GeoLite2 CSV files aren't supported"},
+	}
+
+	for k, test := range tests {
+		ranges, err := readRanges(strings.NewReader(test.csv))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Test %v: expected error %q, got %v", k, test.err, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Test %v: readRanges() failed: %v", k, err)
+		} else if c := ranges.Country(net.ParseIP("1.0.0.23")); c != "AU" {
+			t.Errorf("Test %v: expected AU, got %q", k, c)
+		}
+	}
+}
+
+func TestGeoDBReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	write := func(data string, mod time.Time) {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile() failed: %v", err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatalf("Chtimes() failed: %v", err)
+		}
+	}
+
+	now := time.Now()
+	write("1.0.0.0/24,AU\n", now)
+
+	g, err := OpenGeoDB(path)
+	if err != nil {
+		t.Fatalf("OpenGeoDB() failed: %v", err)
+	}
+	defer g.Close()
+
+	ip := net.ParseIP("1.0.0.23")
+	if c := g.Country(ip); c != "AU" {
+		t.Fatalf("expected AU, got %q", c)
+	}
+
+	// A broken file should keep the old data.
+	write("1.0.0.0/24,AU\nbroken\n", now.Add(time.Minute))
+	if err := g.Reload(); err == nil {
+		t.Errorf("expected Reload() to fail for a broken file")
+	}
+	if c := g.Country(ip); c != "AU" {
+		t.Errorf("expected AU after a failed reload, got %q", c)
+	}
+
+	// Watch should notice the change.
+	go g.Watch(10 * time.Millisecond)
+	write("1.0.0.0/24,NZ\n", now.Add(2*time.Minute))
+
+	for x := 0; x < 100 && g.Country(ip) != "NZ"; x++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := g.Country(ip); c != "NZ" {
+		t.Errorf("expected NZ after the file changed, got %q", c)
+	}
+}
+
+func TestRangesCountry(t *testing.T) {
+	ranges, err := readRanges(strings.NewReader(`10.0.0.0/8,A
+1.0.0.0,1.0.0.0,B
+1.0.0.2,1.0.0.5,C
+2001:db8::/32,D
+::,::ff,E
+`))
+	if err != nil {
+		t.Fatalf("readRanges() failed: %v", err)
+	}
+
+	tests := []struct {
+		addr    string
+		country string
+	}{
+		{addr: "0.255.255.255", country: ""},
+		{addr: "1.0.0.0", country: "B"},
+		{addr: "1.0.0.1", country: ""},
+		{addr: "1.0.0.2", country: "C"},
+		{addr: "1.0.0.5", country: "C"},
+		{addr: "1.0.0.6", country: ""},
+		{addr: "10.0.0.0", country: "A"},
+		{addr: "10.255.255.255", country: "A"},
+		{addr: "11.0.0.0", country: ""},
+		{addr: "255.255.255.255", country: ""},
+		{addr: "::", country: "E"},
+		{addr: "::ff", country: "E"},
+		{addr: "::100", country: ""},
+		{addr: "2001:db8::", country: "D"},
+		{addr: "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", country: "D"},
+		{addr: "2001:db9::", country: ""},
+		{addr: "::ffff:10.1.2.3", country: "A"},
+	}
+
+	for k, test := range tests {
+		if c := ranges.Country(net.ParseIP(test.addr)); c != test.country {
+			t.Errorf("Test %v: %v: expected %q, got %q",
+				k, test.addr, test.country, c)
+		}
+	}
+
+	// The search should agree with the scan.
+	ranges, ips := benchRanges(1000)
+	for _, ip := range ips {
+		if c, l := ranges.Country(ip), linearCountry(ranges, ip); c != l {
+			t.Errorf("%v: search found %q but the scan found %q", ip, c, l)
+		}
+	}
+}
+
+// benchRanges is a helper function that creates n ranges of 256
+// addresses each and n addresses to look up in them.
+func benchRanges(n int) (ipRanges, []net.IP) {
+	ranges := make(ipRanges, 0, n)
+	ips := make([]net.IP, 0, n)
+	for x := 0; x < n; x++ {
+		start := uint32(x) << 8
+		ranges = append(ranges, ipRange{
+			start:   addrFrom(start),
+			end:     addrFrom(start | 0xff),
+			country: fmt.Sprintf("%02d", x%100),
+		})
+
+		// Spread the lookups over all of the ranges.
+		y := uint32(x*7919%n)<<8 | 0x42
+		ips = append(ips, net.IP(addrFrom(y).AsSlice()))
+	}
+
+	return ranges, ips
+}
+
+// addrFrom is a helper function that turns an integer into an IPv4
+// address.
+func addrFrom(i uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(i >> 24), byte(i >> 16),
+		byte(i >> 8), byte(i)})
+}
+
+// linearCountry is the linear scan ipRanges.Country replaced, kept to
+// compare against.
+func linearCountry(ranges ipRanges, ip net.IP) string {
+	addr, _ := netip.AddrFromSlice(ip)
+	addr = addr.Unmap()
+
+	for _, ipr := range ranges {
+		if addr.Compare(ipr.start) >= 0 && addr.Compare(ipr.end) <= 0 {
+			return ipr.country
+		}
+	}
+
+	return ""
+}
+
+func BenchmarkRangesLinear(b *testing.B) {
+	for _, n := range []int{1000, 200000} {
+		ranges, ips := benchRanges(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for x := 0; x < b.N; x++ {
+				linearCountry(ranges, ips[x%len(ips)])
+			}
+		})
+	}
+}
+
+func BenchmarkRangesSearch(b *testing.B) {
+	for _, n := range []int{1000, 200000} {
+		ranges, ips := benchRanges(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for x := 0; x < b.N; x++ {
+				ranges.Country(ips[x%len(ips)])
+			}
+		})
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"path"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // HandlerFunc is a handler for the URL system.
@@ -39,7 +41,12 @@ func GetURLs(ds DataStore, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	marshalAndWrite(w, u)
+	resp := make([]*urlResponse, len(u))
+	for k, x := range u {
+		resp[k] = newURLResponse(x)
+	}
+
+	marshalAndWrite(w, resp)
 }
 
 // CountURLs is a handler func that returns the number of urls in the
@@ -62,17 +69,78 @@ func CountURLs(ds DataStore, w http.ResponseWriter, r *http.Request) {
 
 }
 
+// newURLRequest is the JSON posted to NewURL. It's a URL with some
+// extra fields that aren't stored.
+type newURLRequest struct {
+	URL
+
+	// The short id the user would like to use instead of one being
+	// created for them.
+	Alias string
+
+	// The password users have to enter before they are redirected.
+	Password string
+}
+
+// urlResponse is a URL as it's sent to clients. The password hash is
+// left out and Protected says whether there is one.
+type urlResponse struct {
+	URL
+
+	Protected bool `json:",omitempty"`
+}
+
+// newURLResponse is a helper function that creates the response for
+// the given url.
+func newURLResponse(u *URL) *urlResponse {
+	resp := &urlResponse{URL: *u, Protected: u.PasswordHash != ""}
+	resp.PasswordHash = ""
+	return resp
+}
+
 // NewURL creates a new URL based on the URL given as JSON. The short
 // ID is created, the count is zeroed and the time is set to the
 // current time. The updated URL is returned.
 //
+// The long url is checked and normalized with NormalizeURL and then
+// checked against the server's DestinationPolicy. If the server was
+// created with WithDomains, long urls on those domains are followed to
+// make sure they don't loop (see WithMaxChain). If it can't be used, a
+// 400 Bad Request is returned with JSON in the form: {"error":"..."}.
+//
+// ActiveFrom, ExpiresAt and MaxClicks can be set to control when the
+// url redirects and RedirectStatus to control how (see Redirect). An
+// ExpiresAt in the past or not after ActiveFrom, a negative MaxClicks
+// or a RedirectStatus other than 301, 302, 307 or 308 returns a 400
+// Bad Request.
+//
+// If the JSON contains an Alias, it's used as the short ID instead. An
+// invalid alias returns a 400 Bad Request and one that's already in
+// use returns a 409 Conflict. The first part of the server's API
+// prefix (api by default) is invalid since it can't be redirected.
+// Created ids skip it too.
+//
+// If the JSON contains a Password, users have to enter it before they
+// are redirected. Only its hash is stored and it's never returned.
+// Urls with a password have Protected set to true instead.
+//
+// If the server was created with WithDeduplication and there is no
+// alias, an existing url with the same long url and limits (and no
+// password) is returned instead of creating a new one.
+//
 // This would normally map to something like POST /urls. It
 // does not check any session or admin cookies or anything like
 // that. If you are checking those (and you probably should), you can
 // wrap this handler in another handler.
 func NewURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	(&Server{}).newURL(ds, w, r)
+}
+
+// newURL is the implementation of NewURL that uses the settings of
+// the server.
+func (s *Server) newURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 	// Get the posted data.
-	u := &URL{}
+	req := &newURLRequest{}
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		log.Printf("ReadAll() failed on body: %v", err)
@@ -81,7 +149,7 @@ func NewURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = json.Unmarshal(body, u)
+	err = json.Unmarshal(body, req)
 	if err != nil {
 		log.Printf("Unmarshal() failed on body: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -90,12 +158,73 @@ func NewURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set the fields.
+	u := &req.URL
 	u.Clicks = 0
-	u.Short = ""
+	u.Version = 0
+	u.Short = s.idCodec().Normalize(req.Alias)
 	u.Created = time.Now()
+	u.PasswordHash = ""
+
+	if msg := checkLimits(u); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	} else if !u.ExpiresAt.IsZero() && !u.ExpiresAt.After(u.Created) {
+		writeError(w, http.StatusBadRequest, "ExpiresAt is in the past")
+		return
+	}
+
+	// Make sure we can send people there.
+	err = s.checkLong(ds, u)
+	if _, ok := err.(*InvalidURLError); ok {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("checkLong(%v) failed with: %v", u, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	if !setPassword(w, u, req.Password) {
+		return
+	}
 
-	// Put the URL.
-	_, err = ds.PutURL(u)
+	// Reuse an existing one if we can.
+	if f, ok := ds.(URLFinder); ok && s.dedupe && u.Short == "" {
+		found, err := f.FindURL(u.Long)
+		if err == nil && reusable(found, u) {
+			marshalAndWrite(w, newURLResponse(found))
+			return
+		} else if err != nil && err != ErrNotFound {
+			log.Printf("FindURL(%v) failed with: %v", u.Long, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("oops"))
+			return
+		}
+	}
+
+	if u.Short != "" {
+		if !s.idCodec().Valid(u.Short) || s.reserved(u.Short) {
+			writeError(w, http.StatusBadRequest, "invalid alias")
+			return
+		}
+
+		// Some datastores can't store every valid id.
+		err = createURL(ds, u)
+		if err == ErrExists {
+			writeError(w, http.StatusConflict, "alias already exists")
+			return
+		} else if err == ErrInvalidID || err == ErrOverflow {
+			writeError(w, http.StatusBadRequest, "invalid alias")
+			return
+		}
+	} else if s.ids != nil {
+		err = s.generateID(ds, u)
+	} else {
+		err = s.putURL(ds, u)
+	}
+
+	// Check the put.
 	if err != nil {
 		log.Printf("PutURL(%v) failed on body: %v", u, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -103,7 +232,79 @@ func NewURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	marshalAndWrite(w, u)
+	marshalAndWrite(w, newURLResponse(u))
+}
+
+// putURL is a helper function that puts the url so the datastore
+// creates its short id, trying again if the id is reserved.
+func (s *Server) putURL(ds DataStore, u *URL) error {
+	for {
+		short, err := ds.PutURL(u)
+		if err != nil || !s.reserved(short) {
+			return err
+		}
+
+		// Nothing can reach it, so we throw it away and take the next.
+		if err := ds.DeleteURL(short); err != nil {
+			return err
+		}
+		u.Short = ""
+	}
+}
+
+// checkLimits is a helper function that returns a message describing
+// what's wrong with the limits of the url or an empty string if
+// they're ok.
+func checkLimits(u *URL) string {
+	if u.MaxClicks < 0 {
+		return "MaxClicks can't be negative"
+	} else if !u.ExpiresAt.IsZero() && !u.ActiveFrom.Before(u.ExpiresAt) {
+		return "ActiveFrom isn't before ExpiresAt"
+	} else if u.RedirectStatus != 0 && !redirectStatuses[u.RedirectStatus] {
+		return "RedirectStatus must be 301, 302, 307 or 308"
+	}
+
+	return ""
+}
+
+// checkLong is a helper function that normalizes the long url of u and
+// makes sure we can send people there. If we can't, an
+// *InvalidURLError is returned.
+func (s *Server) checkLong(ds DataStore, u *URL) error {
+	long, err := NormalizeURL(u.Long, s.schemes)
+	if err != nil {
+		return err
+	}
+	u.Long = long
+
+	return s.checkDestination(ds, u)
+}
+
+// reusable is a helper function that returns true if the existing url
+// found while deduplicating can be returned instead of creating the
+// new one.
+func reusable(found, u *URL) bool {
+	return !found.Expired(u.Created) && found.ExpiresAt.Equal(u.ExpiresAt) &&
+		found.MaxClicks == u.MaxClicks && found.ActiveFrom.Equal(u.ActiveFrom) &&
+		found.RedirectStatus == u.RedirectStatus &&
+		found.PasswordHash == "" && u.PasswordHash == ""
+}
+
+// setPassword is a helper function that sets the password of u. If it
+// can't, the error is written and false is returned.
+func setPassword(w http.ResponseWriter, u *URL, password string) bool {
+	err := u.SetPassword(password)
+	if err == bcrypt.ErrPasswordTooLong {
+		writeError(w, http.StatusBadRequest, "password is too long")
+		return false
+	} else if err != nil {
+		log.Printf("SetPassword() failed with: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return false
+	}
+
+	return true
 }
 
 // DeleteURL deletes the url with the short id in the URL.
@@ -113,9 +314,16 @@ func NewURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 // that. If you are checking those (and you probably should), you can
 // wrap this handler in another handler.
 func DeleteURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+	(&Server{}).deleteURL(ds, w, r)
+}
+
+// deleteURL is the implementation of DeleteURL that uses the settings
+// of the server.
+func (s *Server) deleteURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	c := s.idCodec()
+	id := c.Normalize(path.Base(r.URL.Path))
 
-	if !ValidID(id) {
+	if !c.Valid(id) {
 		// An invalid ID should return a not found.
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("not found"))
@@ -123,7 +331,11 @@ func DeleteURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 	}
 
 	err := ds.DeleteURL(id)
-	if err != nil {
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	} else if err != nil {
 		log.Printf("GetUrl(%v) failed with: %v", id, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("oops"))
@@ -133,6 +345,194 @@ func DeleteURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// GetURL is a handler func for getting the url with the short id in
+// the URL. The ETag header is set to its version so it can be given
+// to UpdateURL.
+//
+// This would normally map to something like GET /urls/{id}. It does
+// not check any session or admin cookies or anything like that. If
+// you are checking those (and you probably should), you can wrap this
+// handler in another handler.
+func GetURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	(&Server{}).getURL(ds, w, r)
+}
+
+// getURL is the implementation of GetURL that uses the settings of
+// the server.
+func (s *Server) getURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	c := s.idCodec()
+	id := c.Normalize(path.Base(r.URL.Path))
+
+	if !c.Valid(id) {
+		// An invalid ID should return a not found.
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	}
+
+	// Some datastores return nil instead of ErrNotFound.
+	u, err := ds.GetURL(id)
+	if err == ErrNotFound || (err == nil && u == nil) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	} else if err != nil {
+		log.Printf("GetUrl(%v) failed with: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	w.Header().Set("ETag", etag(u))
+	marshalAndWrite(w, newURLResponse(u))
+}
+
+// etag is a helper function that returns the ETag of the given url.
+func etag(u *URL) string {
+	return fmt.Sprintf(`"%v"`, u.Version)
+}
+
+// UpdateURL is a handler func that changes the url with the short id
+// in the URL. A PUT replaces the fields that can be changed (Long,
+// ActiveFrom, ExpiresAt, MaxClicks, RedirectStatus and Password) with
+// the ones given as JSON. A PATCH only changes the ones that are
+// given, so the password is kept unless a new one (or "" to remove
+// it) is given. The Short, Created and Clicks don't change and the
+// logs and statistics are kept. The updated url is returned.
+//
+// The request has to say which version of the url it's changing,
+// either with an If-Match header containing the ETag from GetURL or
+// with the Version in the JSON. If-Match: * changes whatever version
+// is there. If the url has been changed since, a 412 Precondition
+// Failed is returned so the change can be made again on the new
+// version. If there is no version at all, a 428 Precondition Required
+// is returned.
+//
+// The long url and limits are checked the same way NewURL checks
+// them, except that ExpiresAt can be in the past to expire the url
+// now. Problems are returned as JSON in the form: {"error":"..."}.
+//
+// This would normally map to something like PUT or PATCH /urls/{id}.
+// It does not check any session or admin cookies or anything like
+// that. If you are checking those (and you probably should), you can
+// wrap this handler in another handler.
+func UpdateURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	(&Server{}).updateURL(ds, w, r)
+}
+
+// updateURL is the implementation of UpdateURL that uses the settings
+// of the server.
+func (s *Server) updateURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	c := s.idCodec()
+	id := c.Normalize(path.Base(r.URL.Path))
+
+	if !c.Valid(id) {
+		// An invalid ID should return a not found.
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("ReadAll() failed on body: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	// Some datastores return nil instead of ErrNotFound.
+	old, err := ds.GetURL(id)
+	if err == ErrNotFound || (err == nil && old == nil) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	} else if err != nil {
+		log.Printf("GetUrl(%v) failed with: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	// A PATCH starts with what's there and a PUT starts over.
+	u := &URL{}
+	if r.Method == "PATCH" {
+		c := *old
+		u = &c
+	}
+	hash := u.PasswordHash
+
+	var req struct {
+		Version  *int
+		Password *string
+	}
+	if json.Unmarshal(body, u) != nil || json.Unmarshal(body, &req) != nil {
+		writeError(w, http.StatusBadRequest, "body isn't a valid url")
+		return
+	}
+	u.Short = old.Short
+	u.Created = old.Created
+	u.Clicks = old.Clicks
+	u.PasswordHash = hash
+
+	// Figure out which version they are changing.
+	var version int
+	if match := r.Header.Get("If-Match"); match == "*" {
+		version = old.Version
+	} else if match != "" {
+		if _, err := fmt.Sscanf(match, `"%d"`, &version); err != nil {
+			writeError(w, http.StatusPreconditionFailed,
+				"If-Match doesn't match the url's ETag")
+			return
+		}
+	} else if req.Version != nil {
+		version = *req.Version
+	} else {
+		writeError(w, http.StatusPreconditionRequired,
+			"If-Match or Version is required")
+		return
+	}
+
+	if msg := checkLimits(u); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	if req.Password != nil && !setPassword(w, u, *req.Password) {
+		return
+	}
+
+	err = s.checkLong(ds, u)
+	if _, ok := err.(*InvalidURLError); ok {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("checkLong(%v) failed with: %v", u, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	err = updateURL(ds, u, version)
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	} else if err == ErrVersion {
+		writeError(w, http.StatusPreconditionFailed,
+			"the url has changed since that version")
+		return
+	} else if err != nil {
+		log.Printf("UpdateURL(%v, %v) failed with: %v", u, version, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("oops"))
+		return
+	}
+
+	w.Header().Set("ETag", etag(u))
+	marshalAndWrite(w, newURLResponse(u))
+}
+
 // GetStatistics is a handler func for getting the statistics of a URL.
 //
 // This would normally map to something like GET /stats/{id}. It does not
@@ -140,9 +540,16 @@ func DeleteURL(ds DataStore, w http.ResponseWriter, r *http.Request) {
 // are checking those (and you probably should), you can wrap this
 // handler in another handler.
 func GetStatistics(ds DataStore, w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+	(&Server{}).getStatistics(ds, w, r)
+}
 
-	if !ValidID(id) {
+// getStatistics is the implementation of GetStatistics that uses the
+// settings of the server.
+func (s *Server) getStatistics(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	c := s.idCodec()
+	id := c.Normalize(path.Base(r.URL.Path))
+
+	if !c.Valid(id) {
 		// An invalid ID should return a not found.
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("not found"))
@@ -151,7 +558,10 @@ func GetStatistics(ds DataStore, w http.ResponseWriter, r *http.Request) {
 
 	// Get the data.
 	u, err := ds.GetStatistics(id)
-	if err != nil {
+	if err == ErrNotFound {
+		// No clicks yet, so we can just give them a blank one.
+		u = NewStatistics(id)
+	} else if err != nil {
 		log.Printf("GetStatistics(%v) failed with: %v", id, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("oops"))
@@ -162,14 +572,43 @@ func GetStatistics(ds DataStore, w http.ResponseWriter, r *http.Request) {
 }
 
 // Redirect is a handler func that handles the redirect. Given a short
-// id, it sets the HTTP code to 302 and the Location header. If the
-// short id isn't found, a 404 not found is returned.
+// id, it sets the HTTP code to the url's RedirectStatus (or the
+// server's, see WithRedirectStatus) and the Location header. If the
+// short id isn't found, a 404 not found is returned. HEAD requests
+// get the same response as GET but aren't logged as clicks. Only urls
+// that redirect with 307 or 308 accept other methods, since those are
+// repeated with the same method. Redirects aren't cached unless
+// the server says so (see WithRedirectMaxAge).
 //
-// This would normally map to something like GET /{id}.
+// If the url has expired or has been clicked MaxClicks times, a 410
+// Gone is returned. MaxClicks is compared to the clicks before this
+// one is counted, so many clicks at once can go a little over.
+//
+// If it's before the url's ActiveFrom time, the server's coming soon
+// response is returned instead (see WithComingSoon). These requests
+// aren't logged.
+//
+// If the server has a DestinationPolicy and it doesn't allow the long
+// url, a page saying the link is disabled is returned instead (see
+// WithBlockedStatus).
+//
+// If the url has a password, a form asking for it is returned. It's
+// posted back to the same url and, if it's right, a 303 See Other is
+// returned instead of the 302. The click is only logged then. Wrong
+// passwords are limited per client (see WithPasswordAttempts).
+//
+// This would normally map to something like /{id}.
 func Redirect(ds DataStore, w http.ResponseWriter, r *http.Request) {
-	id := path.Base(r.URL.Path)
+	(&Server{}).redirect(ds, w, r)
+}
+
+// redirect is the implementation of Redirect that uses the settings
+// of the server.
+func (s *Server) redirect(ds DataStore, w http.ResponseWriter, r *http.Request) {
+	c := s.idCodec()
+	id := c.Normalize(path.Base(r.URL.Path))
 
-	if !ValidID(id) {
+	if !c.Valid(id) {
 		// An invalid ID should return a not found.
 		w.WriteHeader(http.StatusNotFound)
 		w.Write([]byte("not found"))
@@ -178,7 +617,11 @@ func Redirect(ds DataStore, w http.ResponseWriter, r *http.Request) {
 
 	// Get the URL in question.
 	u, err := ds.GetURL(id)
-	if err != nil {
+	if err == ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("not found"))
+		return
+	} else if err != nil {
 		log.Printf("GetUrl(%v) failed with: %v", id, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("oops"))
@@ -192,18 +635,54 @@ func Redirect(ds DataStore, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create a Log entry.
-	l := NewLog(id, r)
-	err = ds.LogClick(l)
-	if err != nil {
-		// We shouldn't error out here but we should log it.
-		log.Printf("LogClick(%v) failed (not likely recorded with: %v",
-			l, err)
+	now := time.Now()
+	if u.Expired(now) {
+		w.WriteHeader(http.StatusGone)
+		w.Write([]byte("gone"))
+		return
 	}
 
-	updateStats(ds, u, r)
+	// Don't give away where it's going before it's time.
+	if !u.Active(now) {
+		s.writeComingSoon(w, r)
+		return
+	}
+
+	if !s.allowed(u.Long) {
+		s.writeBlocked(w)
+		return
+	}
+
+	// Only count the click once the password has been entered. A
+	// posted password is followed by a GET.
+	code := s.statusFor(u)
+	if u.PasswordHash != "" {
+		if !s.unlock(w, r, u) {
+			return
+		}
+		code = http.StatusSeeOther
+	} else if r.Method != "GET" && r.Method != "HEAD" &&
+		!keepsMethod(code) {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	// HEAD is answered like GET, but it's link checkers and the like
+	// looking, not a click.
+	if r.Method != "HEAD" {
+		// Create a Log entry.
+		l := NewLog(id, r)
+		l.Addr = s.clientAddr(r)
+		err = ds.LogClick(l)
+		if err != nil {
+			// We shouldn't error out here but we should log it.
+			log.Printf("LogClick(%v) failed (not likely recorded with: %v",
+				l, err)
+		}
+
+		updateStats(ds, u, l, s.geo, s.agents)
+	}
 
-	// Write the redirect.
-	w.Header().Add("Location", u.Long)
-	w.WriteHeader(http.StatusFound)
+	s.writeRedirect(w, u, code, now)
 }
@@ -12,6 +12,7 @@ import (
 	"net/http/httptest"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -178,6 +179,137 @@ func TestDeleteURL(t *testing.T) {
 	}
 }
 
+func TestGetURL(t *testing.T) {
+	ds := prep()
+
+	tests := []struct {
+		id   string
+		code int
+		long string
+	}{
+		{id: "1c", code: http.StatusOK, long: "http://longurl.com/100.html"},
+		{id: "notfound", code: http.StatusNotFound},
+		{id: "bad!id", code: http.StatusNotFound},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://localhost/urls/"+test.id, nil)
+
+		GetURL(ds, w, r)
+
+		if test.code != w.Code {
+			t.Errorf("Test %v: codes not equal: expecting %v, got %v",
+				k, test.code, w.Code)
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		var u URL
+		if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil ||
+			u.Long != test.long {
+			t.Errorf("Test %v: expected long %v, got %v",
+				k, test.long, w.Body.String())
+		}
+
+		if etag := w.Header().Get("ETag"); etag != `"0"` {
+			t.Errorf("Test %v: expected ETag \"0\", got %v", k, etag)
+		}
+	}
+}
+
+func TestUpdateURL(t *testing.T) {
+	ds := prep()
+
+	tests := []struct {
+		method  string
+		id      string
+		ifMatch string
+		body    string
+		code    int
+		etag    string
+		long    string
+		max     int
+	}{
+		// A PATCH only changes what's given.
+		{method: "PATCH", id: "1c", ifMatch: `"0"`, body: `{"MaxClicks":5}`,
+			code: http.StatusOK, etag: `"1"`,
+			long: "http://longurl.com/100.html", max: 5},
+
+		// The old version is stale now.
+		{method: "PATCH", id: "1c", ifMatch: `"0"`, body: `{"MaxClicks":6}`,
+			code: http.StatusPreconditionFailed},
+
+		// A PUT replaces everything and can use the Version in the body.
+		{method: "PUT", id: "1c",
+			body: `{"Long":"http://example.com/new.html","Version":1}`,
+			code: http.StatusOK, etag: `"2"`,
+			long: "http://example.com/new.html"},
+
+		// Something has to say which version is being changed.
+		{method: "PUT", id: "1d", body: `{"Long":"http://example.com/"}`,
+			code: http.StatusPreconditionRequired},
+		{method: "PUT", id: "1d", ifMatch: "*",
+			body: `{"Long":"http://example.com/"}`,
+			code: http.StatusOK, etag: `"1"`, long: "http://example.com/"},
+		{method: "PUT", id: "1e", ifMatch: "W/0",
+			body: `{"Long":"http://example.com/"}`,
+			code: http.StatusPreconditionFailed},
+
+		// The short id and clicks can't be changed.
+		{method: "PATCH", id: "1f", ifMatch: `"0"`,
+			body: `{"Short":"other","Clicks":50}`,
+			code: http.StatusOK, etag: `"1"`,
+			long: "http://longurl.com/103.html"},
+
+		// Invalid updates.
+		{method: "PATCH", id: "1e", ifMatch: `"0"`,
+			body: `{"Long":"javascript:alert(1)"}`,
+			code: http.StatusBadRequest},
+		{method: "PATCH", id: "1e", ifMatch: `"0"`, body: `{"MaxClicks":-1}`,
+			code: http.StatusBadRequest},
+		{method: "PATCH", id: "1e", ifMatch: `"0"`, body: `not json`,
+			code: http.StatusBadRequest},
+		{method: "PUT", id: "notfound", ifMatch: "*",
+			body: `{"Long":"http://example.com/"}`,
+			code: http.StatusNotFound},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://localhost/urls/"+test.id,
+			strings.NewReader(test.body))
+		if test.ifMatch != "" {
+			r.Header.Set("If-Match", test.ifMatch)
+		}
+
+		UpdateURL(ds, w, r)
+
+		if test.code != w.Code {
+			t.Errorf("Test %v: codes not equal: expecting %v, got %v (%v)",
+				k, test.code, w.Code, w.Body.String())
+			continue
+		}
+
+		if test.code != http.StatusOK {
+			continue
+		}
+
+		if etag := w.Header().Get("ETag"); etag != test.etag {
+			t.Errorf("Test %v: expected ETag %v, got %v", k, test.etag, etag)
+		}
+
+		u, _ := ds.GetURL(test.id)
+		if u == nil || u.Short != test.id || u.Long != test.long ||
+			u.MaxClicks != test.max || u.Clicks != int(ShortToInt(test.id)) {
+			t.Errorf("Test %v: expected %v with long %v and MaxClicks %v, "+
+				"got %v", k, test.id, test.long, test.max, u)
+		}
+	}
+}
+
 func TestNewURL(t *testing.T) {
 	ds := prep()
 
@@ -232,6 +364,157 @@ func TestNewURL(t *testing.T) {
 	}
 }
 
+func TestNewURLAlias(t *testing.T) {
+	ds := prep()
+
+	tests := []struct {
+		alias    string
+		code     int
+		expected string
+		err      error
+	}{
+		// Test a new alias.
+		{
+			alias:    "launch2013",
+			code:     http.StatusOK,
+			expected: `"Short":"launch2013"`,
+		},
+
+		// Test one that's taken.
+		{
+			alias:    "1c",
+			code:     http.StatusConflict,
+			expected: `{"error":"alias already exists"}`,
+		},
+
+		// Test an invalid one.
+		{
+			alias:    "launch-2013",
+			code:     http.StatusBadRequest,
+			expected: `{"error":"invalid alias"}`,
+		},
+
+		// Test one the datastore can't store.
+		{
+			alias:    "0launch",
+			code:     http.StatusBadRequest,
+			expected: `{"error":"invalid alias"}`,
+			err:      ErrInvalidID,
+		},
+	}
+
+	for k, test := range tests {
+		if test.err != nil {
+			ds.SetError(test.err, 1)
+		}
+
+		var b bytes.Buffer
+		b.Write([]byte(`{"Long":"http://test.new/","Alias":"` + test.alias + `"}`))
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/urls", &b)
+
+		NewURL(ds, w, r)
+
+		if test.code != w.Code {
+			t.Errorf("Test %v: codes not equal: expecting %v, got %v",
+				k, test.code, w.Code)
+		}
+
+		if !bytes.Contains(w.Body.Bytes(), []byte(test.expected)) {
+			t.Errorf("Test %v: expected body to contain %v, got %v",
+				k, test.expected, w.Body.String())
+		}
+	}
+
+	// The one that was taken shouldn't have changed.
+	u, _ := ds.GetURL("1c")
+	if u.Long != "http://longurl.com/100.html" {
+		t.Errorf("expected taken alias to be unchanged, got %v", u.Long)
+	}
+}
+
+func TestNewURLInvalid(t *testing.T) {
+	ds := prep()
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{body: `{"Long":""}`, expected: `url is empty`},
+		{body: `{"Long":"javascript:alert(1)"}`, expected: `isn't allowed`},
+		{body: `{"Long":"/relative/path"}`, expected: `url must be absolute`},
+		{body: `{"Long":"http:///path"}`, expected: `host is required`},
+		{body: `{"Long":"http://example.com/","MaxClicks":-1}`,
+			expected: `MaxClicks can't be negative`},
+		{body: `{"Long":"http://example.com/",` +
+			`"ExpiresAt":"2013-08-01T00:00:00Z"}`,
+			expected: `ExpiresAt is in the past`},
+		{body: `{"Long":"http://example.com/",` +
+			`"ExpiresAt":"2100-01-01T00:00:00Z",` +
+			`"ActiveFrom":"2100-01-02T00:00:00Z"}`,
+			expected: `ActiveFrom isn't before ExpiresAt`},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/urls",
+			strings.NewReader(test.body))
+
+		NewURL(ds, w, r)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Test %v: expected %v, got %v",
+				k, http.StatusBadRequest, w.Code)
+		}
+
+		var resp struct{ Error string }
+		err := json.Unmarshal(w.Body.Bytes(), &resp)
+		if err != nil || !strings.Contains(resp.Error, test.expected) {
+			t.Errorf("Test %v: expected JSON error containing %v, got %v",
+				k, test.expected, w.Body.String())
+		}
+	}
+}
+
+func TestRedirectExpired(t *testing.T) {
+	ds := prep()
+	now := time.Now()
+
+	tests := []struct {
+		u    *URL
+		code int
+	}{
+		{u: &URL{Short: "future", Long: "http://example.com/",
+			ExpiresAt: now.Add(time.Hour)}, code: http.StatusFound},
+		{u: &URL{Short: "past", Long: "http://example.com/",
+			ExpiresAt: now.Add(-time.Hour)}, code: http.StatusGone},
+		{u: &URL{Short: "some", Long: "http://example.com/",
+			Clicks: 9, MaxClicks: 10}, code: http.StatusFound},
+		{u: &URL{Short: "used", Long: "http://example.com/",
+			Clicks: 10, MaxClicks: 10}, code: http.StatusGone},
+	}
+
+	for k, test := range tests {
+		ds.PutURL(test.u)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://localhost/"+test.u.Short, nil)
+		Redirect(ds, w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+	}
+
+	// The last click should use it up.
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://localhost/some", nil)
+	Redirect(ds, w, r)
+	if w.Code != http.StatusGone {
+		t.Errorf("expected the 10th click to use it up, got %v", w.Code)
+	}
+}
+
 func TestGetStatistics(t *testing.T) {
 	ds := prep()
 
@@ -244,7 +527,7 @@ func TestGetStatistics(t *testing.T) {
 		// Test in the middle
 		{
 			id:       "1c",
-			expected: `{"Short":"1c","Clicks":100,"LastUpdated":"0001-01-01T00:00:00Z","Referrers":null,"Browsers":null,"Countries":null,"Platforms":null,"Hours":null}`,
+			expected: `{"Short":"1c","Clicks":100,"LastUpdated":"0001-01-01T00:00:00Z","Referrers":null,"Browsers":null,"Countries":null,"Platforms":null,"Devices":null,"Hours":null}`,
 		},
 
 		// Test a failure.
@@ -352,6 +635,51 @@ func TestRedirect(t *testing.T) {
 	}
 }
 
+func TestRedirectHead(t *testing.T) {
+	ds := prep()
+	u := &URL{Short: "secret", Long: "http://example.com/"}
+	u.SetPassword("hunter2")
+	ds.PutURL(u)
+
+	tests := []struct {
+		id       string
+		code     int
+		location string
+	}{
+		{id: "1c", code: http.StatusFound,
+			location: "http://longurl.com/100.html"},
+		{id: "secret", code: http.StatusOK},
+		{id: "198djd81jd", code: http.StatusNotFound},
+	}
+
+	for k, test := range tests {
+		logs := len(ds.logs[test.id])
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("HEAD", "http://localhost/"+test.id, nil)
+
+		Redirect(ds, w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+
+		if l := w.Header().Get("Location"); l != test.location {
+			t.Errorf("Test %v: expected Location %q, got %q",
+				k, test.location, l)
+		}
+
+		if len(ds.logs[test.id]) != logs {
+			t.Errorf("Test %v: expected HEAD not to be logged, got %v logs",
+				k, len(ds.logs[test.id]))
+		}
+	}
+
+	if got, _ := ds.GetURL("1c"); got.Clicks != 100 {
+		t.Errorf("expected HEAD not to count as a click, got %v clicks",
+			got.Clicks)
+	}
+}
+
 func prep() *mds {
 	ds := &mds{
 		urls:  make(map[string]*URL),
@@ -483,12 +811,8 @@ func (ds *mds) GetURL(short string) (*URL, error) {
 		return nil, nil
 	}
 
-	return &URL{
-		Short:   got.Short,
-		Long:    got.Long,
-		Created: got.Created,
-		Clicks:  got.Clicks,
-	}, nil
+	c := *got
+	return &c, nil
 }
 
 func (ds *mds) PutURL(url *URL) (string, error) {
@@ -503,16 +827,26 @@ func (ds *mds) PutURL(url *URL) (string, error) {
 		url.Short = id
 	}
 
-	ds.urls[url.Short] = &URL{
-		Short:   url.Short,
-		Long:    url.Long,
-		Created: url.Created,
-		Clicks:  url.Clicks,
-	}
+	c := *url
+	ds.urls[url.Short] = &c
 
 	return url.Short, nil
 }
 
+func (ds *mds) FindURL(long string) (*URL, error) {
+	if err := ds.error(); err != nil {
+		return nil, err
+	}
+
+	for _, got := range ds.urls {
+		if got.Long == long {
+			return ds.GetURL(got.Short)
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
 func (ds *mds) DeleteURL(short string) error {
 	if err := ds.error(); err != nil {
 		return err
@@ -8,101 +8,58 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
+	"net"
 	"net/http"
+	"net/netip"
 	neturl "net/url"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
-	"unicode/utf8"
 )
 
-var (
-	// re is the regular expression used to check for valid ids
-	re = regexp.MustCompile("^[0-9a-zA-Z]+$")
-)
-
-const (
-	// The base we are working in ([0-9a-zA-Z])
-	base = 62
-)
-
-// ValidID returns true if the given string is a valid ID.
+// ValidID returns true if the given string is a valid ID for the
+// DefaultCodec.
 func ValidID(id string) bool {
-	return re.MatchString(id)
+	return DefaultCodec.Valid(id)
 }
 
 // IntToShort returns the string representation of the given
-// integer. Values less than 0 return 0. Otherwise, it will be some
-// string that includes the characters 0-9, a-z, and A-Z.
+// integer using the DefaultCodec. Values less than 0 return 0.
+// Otherwise, it will be some string that includes the characters
+// 0-9, a-z, and A-Z.
 func IntToShort(i int64) string {
-	if i <= 0 {
-		return "0"
-	}
-
-	s := ""
-	for i > 0 {
-		r := i % base
-		s = digit(r) + s
-		i = i / base
-	}
-
-	return s
+	return DefaultCodec.Encode(i)
 }
 
 // ShortToInt returns the integer representation of the given short
-// id.
+// id using the DefaultCodec. Invalid ids and those too large for an
+// int64 return 0. Use ParseShort if you need to know why.
 func ShortToInt(s string) int64 {
-	// Split it into its parts.
-	chars := strings.Split(s, "")
-
-	// Loop through each character.
-	var sum int64
-	l := len(chars) - 1
-	for x := l; x >= 0; x-- {
-		// Increae by the value of the current character * base^i. We are
-		// reading it from back to front, so i = l-x.
-		sum = sum + char(chars[x])*int64(math.Pow(float64(base), float64(l-x)))
-	}
+	return DefaultCodec.Decode(s)
+}
 
-	return sum
+// ParseShort returns the integer representation of the given short
+// id using the DefaultCodec. It returns ErrInvalidID if the id has
+// characters that aren't allowed and ErrOverflow if it's too large
+// for an int64.
+func ParseShort(s string) (int64, error) {
+	return DefaultCodec.Parse(s)
 }
 
 // Char converts the given single character string into its integer
 // representation.
 func char(c string) int64 {
-	r, s := utf8.DecodeLastRuneInString(c)
-	if s != 1 {
+	if len(c) != 1 {
 		return 0
 	}
 
-	if r >= 48 && r <= 57 {
-		return int64(r - 48)
-	} else if r >= 65 && r <= 90 {
-		return int64(r - 55)
-	} else if r >= 97 && r <= 122 {
-		return int64(r - 61)
-	}
-
-	return 0
+	return DefaultCodec.value(c[0])
 }
 
 // Digit convers the given integer into its representative single
 // digit in the language.
 func digit(i int64) string {
-	if i < 10 {
-		i = i + 48 // 48 is where 0-9 starts
-	} else if i >= 10 && i <= 35 {
-		i = i + 55 // 55+10 is where A-Z starts.
-	} else if i >= 36 && i < 62 {
-		i = i + 61 // 61+36 is where a-z starts.
-	} else {
-		// Anything else doesn't fit into [0-9a-zA-Z].
-		return ""
-	}
-
-	return string([]byte{byte(i)})
+	return DefaultCodec.digit(i)
 }
 
 // paramGetInt is a helper function that returns the integer value of the
@@ -163,131 +120,62 @@ func marshalAndWrite(w http.ResponseWriter, i interface{}) {
 
 }
 
-// parseUserAgent looks for keywords in the given string and returns
-// the best guess for the browser and platform.
-func parseUserAgent(ua string) (string, string) {
-	browser := "Unknown"
-	platform := "Unknown"
-
-	// These are in this order because some contain the others.
-	browsers := []string{
-		"Chrome",
-		"Safari",
-		"Firefox",
-		"MSIE",
-		"Opera",
-	}
-	for _, search := range browsers {
-		if strings.Contains(ua, search) {
-			browser = search
-			break
-		}
-	}
-
-	platforms := map[string]string{
-		"Linux":          "Linux",
-		"Windows NT 5.1": "Windows XP",
-		"Windows NT 6.1": "Windows 7",
-		"Windows NT 6.2": "Windows 8",
-		"Mac OS X":       "Mac OS X",
-		"iPhone":         "iOS",
-		"iPad":           "iOS",
-	}
-
-	for search, value := range platforms {
-		if strings.Contains(ua, search) {
-			platform = value
-			break
-		}
-	}
+// writeError is a helper function that writes the given status code
+// and an error message as JSON in the form: {"error":"..."}.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	enc, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{msg})
 
-	return browser, platform
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(enc)
 }
 
 // Determine country attempts to determine the country of origin by
-// the IP Address.
-func determineCountry(addr string) string {
+// the IP Address using the given GeoResolver. The address can have a
+// port (like 1.2.3.4:80 or [::1]:80) or not.
+func determineCountry(g GeoResolver, addr string) string {
 	country := "Unknown"
 
-	// Get the parts.
-	addr = strings.Split(addr, ":")[0]
-	parts := strings.Split(addr, ".")
-	if len(parts) != 4 {
+	ip, ok := parseAddr(addr)
+	if g == nil || !ok {
 		return country
 	}
 
-	// get the integer equivalent.
-	total := 0
-	i, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return country
+	if c := g.Country(net.IP(ip.AsSlice())); c != "" {
+		return c
 	}
-	total = total + 16777216*i
 
-	i, err = strconv.Atoi(parts[1])
-	if err != nil {
-		return country
-	}
-	total = total + 65536*i
+	return country
+}
 
-	i, err = strconv.Atoi(parts[2])
-	if err != nil {
-		return country
+// parseAddr is a helper function that parses an IP address with or
+// without a port. IPv4-mapped IPv6 addresses are returned as the IPv4
+// address they contain.
+func parseAddr(addr string) (netip.Addr, bool) {
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return ap.Addr().Unmap(), true
 	}
-	total = total + 256*i
 
-	i, err = strconv.Atoi(parts[3])
+	ip, err := netip.ParseAddr(strings.TrimSuffix(
+		strings.TrimPrefix(addr, "["), "]"))
 	if err != nil {
-		return country
-	}
-	total = total + i
-
-	for _, ipr := range ipLookup {
-		if total > ipr.start && total < ipr.end {
-			return ipr.country
-		}
+		return netip.Addr{}, false
 	}
 
-	return country
+	return ip.Unmap(), true
 }
 
 // updateStats is a helper function that updates the stats of a url
-// based on a request.
-func updateStats(ds DataStore, url *URL, r *http.Request) {
-	// TODO no testing is being done on this since we removed the
-	// CreateStatistics but the code hasn't changed. If it does, we
-	// should probably start testing this.
-	stats, err := ds.GetStatistics(url.Short)
-	if err == ErrNotFound {
-		stats = NewStatistics(url.Short)
-	} else if err != nil {
-		log.Printf(
-			"updateStats failed at GetStatistics. Skipping update: %v",
-			err)
-		return
-	}
-
-	// Create the maps if they weren't created.
-	if stats.Referrers == nil {
-		stats.Referrers = make(map[string]int)
-	}
-	if stats.Browsers == nil {
-		stats.Browsers = make(map[string]int)
-	}
-	if stats.Countries == nil {
-		stats.Countries = make(map[string]int)
-	}
-	if stats.Platforms == nil {
-		stats.Platforms = make(map[string]int)
-	}
-	if stats.Hours == nil {
-		stats.Hours = make(map[string]int)
-	}
-
+// based on the log of a click. The country is found with geo and the
+// user agent is parsed with agents, or DefaultUAParser if it's nil.
+func updateStats(ds DataStore, url *URL, l *Log, geo GeoResolver,
+	agents *UAParser) {
 	now := time.Now()
 
 	// Set the various values we'll save.
-	referrer := r.Header.Get("Referer")
+	referrer := l.Referrer
 	if referrer == "" {
 		referrer = "Unknown"
 	} else {
@@ -299,31 +187,39 @@ func updateStats(ds DataStore, url *URL, r *http.Request) {
 		}
 	}
 
-	browser, platform := parseUserAgent(r.Header.Get("User-Agent"))
-	country := determineCountry(r.RemoteAddr)
+	if agents == nil {
+		agents = DefaultUAParser
+	}
+	ua := agents.Parse(l.UserAgent)
+	browser, platform, device := ua.Browser, ua.Platform(), ua.Device
+	country := determineCountry(geo, l.Addr)
 	hour := fmt.Sprintf("%04d%02d%02d%02d%02d",
 		now.Year(), now.Month(), now.Day(),
 		now.Hour(), now.Minute())
 
-	// Update the values.
-	stats.Referrers[referrer] = stats.Referrers[referrer] + 1
-	stats.Browsers[browser] = stats.Browsers[browser] + 1
-	stats.Countries[country] = stats.Countries[country] + 1
-	stats.Platforms[platform] = stats.Platforms[platform] + 1
-	stats.Hours[hour] = stats.Hours[hour] + 1
-
-	// set the short name in case it's a new one.
-	stats.Short = url.Short
+	// These are the counts of this click. They are added to the
+	// stored ones so clicks at the same time don't lose each other.
+	stats := NewStatistics(url.Short)
+	stats.Referrers[referrer] = 1
+	stats.Browsers[browser] = 1
+	stats.Countries[country] = 1
+	stats.Platforms[platform] = 1
+	stats.Devices[device] = 1
+	stats.Hours[hour] = 1
+	stats.Clicks = 1
+	stats.LastUpdated = now
 
 	// Update the clicks.
-	stats.Clicks += 1
 	url.Clicks += 1
 
-	// Set the update time to the newest time.
-	stats.LastUpdated = now
-
-	// Put the Url for the Clicks count.
-	_, err = ds.PutURL(url)
+	// Put the Url for the Clicks count. If we can, only touch the
+	// count so we don't undo an update made since we got the url.
+	var err error
+	if c, ok := ds.(ClickCounter); ok {
+		err = c.AddClick(url.Short)
+	} else {
+		_, err = ds.PutURL(url)
+	}
 	if err != nil {
 		log.Printf(
 			"updateStats failed at PutURL. click update failed: %v",
@@ -331,11 +227,11 @@ func updateStats(ds DataStore, url *URL, r *http.Request) {
 		return
 	}
 
-	// Put the Statistics.
-	err = ds.PutStatistics(stats)
+	// Add to the Statistics.
+	err = addStatistics(ds, stats)
 	if err != nil {
 		log.Printf(
-			"updateStats failed at PutStatistics. stat update failed: %v",
+			"updateStats failed at addStatistics. stat update failed: %v",
 			err)
 		return
 	}
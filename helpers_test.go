@@ -739,64 +739,6 @@ func TestMarshalAndWrite(t *testing.T) {
 	}
 }
 
-func TestParseUserAgent(t *testing.T) {
-	tests := []struct {
-		ua       string
-		browser  string
-		platform string
-	}{
-		{
-			ua:       "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
-			browser:  "Chrome",
-			platform: "Windows 7",
-		},
-		{
-			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
-			browser:  "Chrome",
-			platform: "Mac OS X",
-		},
-		{
-			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_4) AppleWebKit/536.30.1 (KHTML, like Gecko) Version/6.0.5 Safari/536.30.1",
-			browser:  "Safari",
-			platform: "Mac OS X",
-		},
-		{
-			ua:       "Mozilla/5.0 (Windows NT 6.1; WOW64; rv:23.0) Gecko/20100101 Firefox/23.0",
-			browser:  "Firefox",
-			platform: "Windows 7",
-		},
-		{
-			ua:       "Mozilla/5.0 (Windows NT 6.2; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
-			browser:  "Chrome",
-			platform: "Windows 8",
-		},
-		{
-			ua:       "Opera/9.80 (Windows NT 6.1; WOW64) Presto/2.12.388 Version/12.16",
-			browser:  "Opera",
-			platform: "Windows 7",
-		},
-		{
-			ua:       "",
-			browser:  "Unknown",
-			platform: "Unknown",
-		},
-	}
-
-	for k, test := range tests {
-		browser, platform := parseUserAgent(test.ua)
-
-		if test.browser != browser {
-			t.Errorf("Test %v: expected browser '%v' but got '%v': %v",
-				k, test.browser, browser, test.ua)
-		}
-
-		if test.platform != platform {
-			t.Errorf("Test %v: expected platform '%v' but got '%v': %v",
-				k, test.platform, platform, test.ua)
-		}
-	}
-}
-
 func TestDetermineCountry(t *testing.T) {
 	tests := []struct {
 		addr    string
@@ -845,14 +787,79 @@ func TestDetermineCountry(t *testing.T) {
 			addr:    "190.109.96.35",
 			country: "CO",
 		},
+
+		// Test the ends of the ranges.
+		{
+			addr:    "1.0.0.0",
+			country: "AU",
+		},
+		{
+			addr:    "223.255.255.255",
+			country: "AU",
+		},
+		{
+			addr:    "190.109.111.255",
+			country: "CO",
+		},
+		{
+			addr:    "190.109.112.0",
+			country: "Unknown",
+		},
+
+		// Test ports and IPv6.
+		{
+			addr:    "1.0.0.23:4321",
+			country: "AU",
+		},
+		{
+			addr:    "[::1]:8080",
+			country: "Unknown",
+		},
+		{
+			addr:    "2001:db8::1",
+			country: "NL",
+		},
+		{
+			addr:    "[2001:db8:ffff::1]:443",
+			country: "NL",
+		},
+		{
+			addr:    "[2001:db9::1]:443",
+			country: "Unknown",
+		},
+		{
+			addr:    "::ffff:206.251.44.94",
+			country: "US",
+		},
+		{
+			addr:    "[::ffff:190.109.96.35]:80",
+			country: "CO",
+		},
+		{
+			addr:    "[::1",
+			country: "Unknown",
+		},
 	}
 
-	for k, test := range tests {
-		country := determineCountry(test.addr)
-		if country != test.country {
-			t.Errorf("Test %v: expected country '%v' but got '%v': %v",
-				k, test.country, country, test.addr)
+	for _, path := range []string{"testdata/countries.csv",
+		"testdata/countries.mmdb"} {
+		g, err := OpenGeoDB(path)
+		if err != nil {
+			t.Fatalf("OpenGeoDB(%v) failed: %v", path, err)
 		}
+
+		for k, test := range tests {
+			country := determineCountry(g, test.addr)
+			if country != test.country {
+				t.Errorf("Test %v: %v: expected country '%v' but got '%v': %v",
+					k, path, test.country, country, test.addr)
+			}
+		}
+	}
+
+	if country := determineCountry(nil, "1.0.0.23"); country != "Unknown" {
+		t.Errorf("expected country 'Unknown' without a GeoResolver, got '%v'",
+			country)
 	}
 }
 
@@ -0,0 +1,276 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+var (
+	// ErrNoSequence is returned by IDGenerators that need a sequence
+	// from a DataStore that doesn't implement Sequencer.
+	ErrNoSequence = errors.New("datastore doesn't implement Sequencer")
+
+	// ErrOutOfRange is returned when a value is too large to be
+	// obfuscated.
+	ErrOutOfRange = errors.New("value out of range")
+)
+
+// maxIDAttempts is the number of times we'll ask an IDGenerator for a
+// new id when the ones it gives us are already taken.
+const maxIDAttempts = 10
+
+// IDGenerator creates the short ids for new urls. Without one, the
+// DataStore creates them when the url is put, which usually makes
+// them sequential.
+type IDGenerator interface {
+	// NewID returns a new short id. It doesn't have to be unused. If
+	// it's taken, NewID is called again.
+	NewID(ds DataStore) (string, error)
+}
+
+// Sequencer is an optional interface a DataStore can implement to
+// hand out unique, increasing integers. It's used by IDGenerators
+// like ObfuscatedIDs that transform a sequence.
+type Sequencer interface {
+	NextID() (int64, error)
+}
+
+// WithIDGenerator uses the given IDGenerator to create the short ids
+// of new urls.
+func WithIDGenerator(g IDGenerator) Option {
+	return func(s *Server) {
+		s.ids = g
+	}
+}
+
+// generateID is a helper function that creates the url with a short
+// id from the server's IDGenerator, trying again if it's taken or the
+// datastore can't store it.
+func (s *Server) generateID(ds DataStore, u *URL) error {
+	for x := 0; x < maxIDAttempts; x++ {
+		id, err := s.ids.NewID(ds)
+		if err != nil {
+			return err
+		}
+
+		if s.reserved(id) {
+			continue
+		}
+
+		// Some datastores can't store every id, like gae with 0.
+		u.Short = id
+		err = createURL(ds, u)
+		if err != ErrExists && err != ErrInvalidID {
+			return err
+		}
+	}
+
+	u.Short = ""
+	return fmt.Errorf("no unused id after %v attempts", maxIDAttempts)
+}
+
+// SequentialIDs creates ids from the DataStore's Sequencer. They are
+// like the ones DataStores create themselves but can use a codec
+// other than DefaultCodec.
+type SequentialIDs struct {
+	// The codec used to turn the values into ids. It defaults to
+	// DefaultCodec.
+	Codec *Codec
+}
+
+// NewID implements the IDGenerator interface.
+func (si *SequentialIDs) NewID(ds DataStore) (string, error) {
+	seq, ok := ds.(Sequencer)
+	if !ok {
+		return "", ErrNoSequence
+	}
+
+	n, err := seq.NextID()
+	if err != nil {
+		return "", err
+	}
+
+	if si.Codec != nil {
+		return si.Codec.Encode(n), nil
+	}
+	return IntToShort(n), nil
+}
+
+// RandomIDs creates random ids of a fixed length. The length should
+// be long enough that collisions are rare. Each extra character makes
+// them (the size of the alphabet) times less likely.
+type RandomIDs struct {
+	// The number of characters in each id. It defaults to 8.
+	Length int
+
+	// The codec whose alphabet is used. It defaults to DefaultCodec.
+	Codec *Codec
+}
+
+// NewID implements the IDGenerator interface.
+func (ri *RandomIDs) NewID(ds DataStore) (string, error) {
+	l := ri.Length
+	if l <= 0 {
+		l = 8
+	}
+
+	c := ri.Codec
+	if c == nil {
+		c = DefaultCodec
+	}
+
+	max := big.NewInt(c.Base())
+	id := make([]byte, l)
+	for x := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[x] = c.alphabet[n.Int64()]
+	}
+
+	return string(id), nil
+}
+
+// ObfuscatedIDs creates ids from the DataStore's Sequencer by running
+// each value through a permutation keyed with Key. The ids are about
+// as short as sequential ones but you can't guess the next one
+// without the key. The permutation can be reversed with Deobfuscate.
+//
+// Values less than 2^Bits map to values less than 2^Bits. Larger
+// values map into successively larger ranges (each two bits wider
+// than the last) so ids only grow when they have to.
+type ObfuscatedIDs struct {
+	// The secret key. Changing it changes every id that will be
+	// created, so keep it constant for the life of a datastore.
+	Key []byte
+
+	// The number of bits in the smallest range. It is rounded up to an
+	// even number and defaults to 32, which makes ids up to six
+	// characters long in base 62.
+	Bits uint
+
+	// The codec used to turn the values into ids. It defaults to
+	// DefaultCodec.
+	Codec *Codec
+}
+
+// NewID implements the IDGenerator interface.
+func (oi *ObfuscatedIDs) NewID(ds DataStore) (string, error) {
+	seq, ok := ds.(Sequencer)
+	if !ok {
+		return "", ErrNoSequence
+	}
+
+	n, err := seq.NextID()
+	if err != nil {
+		return "", err
+	}
+
+	o, err := oi.Obfuscate(n)
+	if err != nil {
+		return "", err
+	}
+
+	if oi.Codec != nil {
+		return oi.Codec.Encode(o), nil
+	}
+	return IntToShort(o), nil
+}
+
+// Obfuscate returns the permuted value of n.
+func (oi *ObfuscatedIDs) Obfuscate(n int64) (int64, error) {
+	return oi.walk(n, oi.permute)
+}
+
+// Deobfuscate reverses Obfuscate.
+func (oi *ObfuscatedIDs) Deobfuscate(n int64) (int64, error) {
+	return oi.walk(n, oi.unpermute)
+}
+
+// walk is a helper function that finds the range n is in and applies
+// f until the value falls back into that range. Because f is a
+// permutation of a superset of the range, this is a permutation of
+// the range itself.
+func (oi *ObfuscatedIDs) walk(n int64,
+	f func(x uint64, w uint) uint64) (int64, error) {
+
+	if n < 0 {
+		return 0, ErrOutOfRange
+	}
+
+	b := oi.Bits
+	if b == 0 {
+		b = 32
+	}
+	b += b % 2
+
+	// Find the range. The smallest is [0, 2^b) and the others are
+	// [2^(w-2), 2^w).
+	w := b
+	var lo uint64
+	for l := uint(bits.Len64(uint64(n))); l > w; w += 2 {
+		lo = 1 << w
+	}
+	if w > 62 {
+		return 0, ErrOutOfRange
+	}
+	hi := uint64(1) << w
+
+	x := uint64(n)
+	for {
+		x = f(x, w)
+		if x >= lo && x < hi {
+			return int64(x), nil
+		}
+	}
+}
+
+// rounds is the number of Feistel rounds used by permute.
+const rounds = 4
+
+// round is the Feistel round function. It returns the keyed hash of
+// the round number and the given half, truncated to half bits.
+func (oi *ObfuscatedIDs) round(r int, x uint64, half uint) uint64 {
+	var buf [9]byte
+	buf[0] = byte(r)
+	binary.BigEndian.PutUint64(buf[1:], x)
+
+	mac := hmac.New(sha256.New, oi.Key)
+	mac.Write(buf[:])
+	sum := mac.Sum(nil)
+
+	return binary.BigEndian.Uint64(sum) & (1<<half - 1)
+}
+
+// permute is a balanced Feistel network over w bits.
+func (oi *ObfuscatedIDs) permute(x uint64, w uint) uint64 {
+	half := w / 2
+	mask := uint64(1)<<half - 1
+	l, r := x>>half, x&mask
+	for i := 0; i < rounds; i++ {
+		l, r = r, l^oi.round(i, r, half)
+	}
+	return l<<half | r
+}
+
+// unpermute is the inverse of permute.
+func (oi *ObfuscatedIDs) unpermute(x uint64, w uint) uint64 {
+	half := w / 2
+	mask := uint64(1)<<half - 1
+	l, r := x>>half, x&mask
+	for i := rounds - 1; i >= 0; i-- {
+		l, r = r^oi.round(i, l, half), l
+	}
+	return l<<half | r
+}
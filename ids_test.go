@@ -0,0 +1,219 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRandomIDs(t *testing.T) {
+	tests := []struct {
+		length   int
+		expected int
+	}{
+		{length: 0, expected: 8},
+		{length: 4, expected: 4},
+		{length: 20, expected: 20},
+	}
+
+	for k, test := range tests {
+		ri := &RandomIDs{Length: test.length}
+		id, err := ri.NewID(nil)
+		if err != nil {
+			t.Fatalf("Test %v: NewID() failed: %v", k, err)
+		}
+
+		if len(id) != test.expected || !ValidID(id) {
+			t.Errorf("Test %v: expected valid id of length %v, got %q",
+				k, test.expected, id)
+		}
+	}
+}
+
+func TestObfuscatedIDs(t *testing.T) {
+	oi := &ObfuscatedIDs{Key: []byte("secret"), Bits: 16}
+
+	// Every value in the smallest range should map to a unique value
+	// in that range and come back again.
+	seen := make(map[int64]bool)
+	sequential := 0
+	for n := int64(0); n < 1<<16; n++ {
+		o, err := oi.Obfuscate(n)
+		if err != nil {
+			t.Fatalf("Obfuscate(%v) failed: %v", n, err)
+		}
+
+		if o < 0 || o >= 1<<16 {
+			t.Fatalf("Obfuscate(%v) = %v is out of range", n, o)
+		}
+
+		if seen[o] {
+			t.Fatalf("Obfuscate(%v) = %v was already used", n, o)
+		}
+		seen[o] = true
+
+		if o == n+1 {
+			sequential++
+		}
+
+		d, err := oi.Deobfuscate(o)
+		if err != nil || d != n {
+			t.Fatalf("Deobfuscate(%v) = (%v, %v), expected %v", o, d, err, n)
+		}
+	}
+
+	if sequential > 100 {
+		t.Errorf("too many sequential values: %v", sequential)
+	}
+
+	// Larger values should stay in their ranges.
+	tests := []struct {
+		n  int64
+		lo int64
+		hi int64
+	}{
+		{n: 1 << 16, lo: 1 << 16, hi: 1 << 18},
+		{n: 1<<18 - 1, lo: 1 << 16, hi: 1 << 18},
+		{n: 1 << 18, lo: 1 << 18, hi: 1 << 20},
+		{n: 1<<62 - 1, lo: 1 << 60, hi: 1 << 62},
+	}
+
+	for k, test := range tests {
+		o, err := oi.Obfuscate(test.n)
+		if err != nil {
+			t.Fatalf("Test %v: Obfuscate(%v) failed: %v", k, test.n, err)
+		}
+
+		if o < test.lo || o >= test.hi {
+			t.Errorf("Test %v: Obfuscate(%v) = %v, expected [%v, %v)",
+				k, test.n, o, test.lo, test.hi)
+		}
+
+		if d, _ := oi.Deobfuscate(o); d != test.n {
+			t.Errorf("Test %v: Deobfuscate(%v) = %v, expected %v",
+				k, o, d, test.n)
+		}
+	}
+
+	if _, err := oi.Obfuscate(1 << 62); err != ErrOutOfRange {
+		t.Errorf("expected ErrOutOfRange, got %v", err)
+	}
+
+	if _, err := oi.NewID(prep()); err != ErrNoSequence {
+		t.Errorf("expected ErrNoSequence, got %v", err)
+	}
+}
+
+// fixedIDs is an IDGenerator that returns the given ids in order.
+type fixedIDs []string
+
+func (f *fixedIDs) NewID(ds DataStore) (string, error) {
+	id := (*f)[0]
+	*f = (*f)[1:]
+	return id, nil
+}
+
+func TestServerIDGenerator(t *testing.T) {
+	tests := []struct {
+		ids      fixedIDs
+		err      error
+		code     int
+		expected string
+	}{
+		// Test one that isn't taken.
+		{
+			ids:      fixedIDs{"abcdef"},
+			code:     http.StatusOK,
+			expected: `"Short":"abcdef"`,
+		},
+
+		// Test some that are taken.
+		{
+			ids:      fixedIDs{"1c", "1d", "ghijkl"},
+			code:     http.StatusOK,
+			expected: `"Short":"ghijkl"`,
+		},
+
+		// Test one the datastore can't store.
+		{
+			ids:      fixedIDs{"0", "ghijkl"},
+			err:      ErrInvalidID,
+			code:     http.StatusOK,
+			expected: `"Short":"ghijkl"`,
+		},
+
+		// Test running out of attempts.
+		{
+			ids: fixedIDs{"1", "2", "3", "4", "5", "6", "7", "8", "9", "A",
+				"B"},
+			code:     http.StatusInternalServerError,
+			expected: `oops`,
+		},
+	}
+
+	for k, test := range tests {
+		ids := test.ids
+		ds := prep()
+		if test.err != nil {
+			ds.SetError(test.err, 1)
+		}
+		s := NewServer(ds, WithIDGenerator(&ids))
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/api/urls",
+			bytes.NewBufferString(`{"Long":"http://example.com/"}`))
+
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected code %v, got %v", k, test.code, w.Code)
+		}
+
+		if !bytes.Contains(w.Body.Bytes(), []byte(test.expected)) {
+			t.Errorf("Test %v: expected body to contain %v, got %v",
+				k, test.expected, w.Body.String())
+		}
+	}
+}
+
+// seqds is a datastore with a Sequencer.
+type seqds struct {
+	*mds
+	next int64
+}
+
+func (ds *seqds) NextID() (int64, error) {
+	ds.next++
+	return ds.next, nil
+}
+
+func TestSequentialIDs(t *testing.T) {
+	tests := []struct {
+		codec    *Codec
+		expected []string
+	}{
+		{codec: nil, expected: []string{"1", "2", "3"}},
+		{codec: MustCodec("ab"), expected: []string{"b", "ba", "bb"}},
+	}
+
+	for k, test := range tests {
+		si := &SequentialIDs{Codec: test.codec}
+		ds := &seqds{mds: prep()}
+		for _, expected := range test.expected {
+			id, err := si.NewID(ds)
+			if err != nil || id != expected {
+				t.Errorf("Test %v: expected %q but got (%q, %v)",
+					k, expected, id, err)
+			}
+		}
+	}
+
+	if _, err := (&SequentialIDs{}).NewID(prep()); err != ErrNoSequence {
+		t.Errorf("expected ErrNoSequence, got %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package memory is an implementation of the urls.DataStore that
+// keeps everything in memory. Nothing is persisted, so it's best
+// suited for small deployments, demos and tests.
+package memory
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/icub3d/urls"
+)
+
+// DataStore implements the urls.DataStore interface. It is safe for
+// concurrent use.
+type DataStore struct {
+	mu    sync.RWMutex
+	urls  map[string]*urls.URL
+	stats map[string]*urls.Statistics
+	logs  map[string][]*urls.Log
+	next  int64
+
+	// longs maps each long url to the set of short ids using it.
+	longs map[string]map[string]bool
+}
+
+// NewDataStore creates a new empty datastore.
+func NewDataStore() *DataStore {
+	return &DataStore{
+		urls:  make(map[string]*urls.URL),
+		stats: make(map[string]*urls.Statistics),
+		logs:  make(map[string][]*urls.Log),
+		next:  1,
+		longs: make(map[string]map[string]bool),
+	}
+}
+
+// CountURLs implements the urls.DataStore interface.
+func (ds *DataStore) CountURLs() (int, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	return len(ds.urls), nil
+}
+
+// GetURLs implements the urls.DataStore interface.
+func (ds *DataStore) GetURLs(limit, offset int) ([]*urls.URL, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	all := make(byCreated, 0, len(ds.urls))
+	for _, u := range ds.urls {
+		all = append(all, u)
+	}
+	sort.Sort(all)
+
+	start, end := window(len(all), limit, offset)
+	us := make([]*urls.URL, 0, end-start)
+	for _, u := range all[start:end] {
+		us = append(us, copyURL(u))
+	}
+
+	return us, nil
+}
+
+// GetURL implements the urls.DataStore interface.
+func (ds *DataStore) GetURL(short string) (*urls.URL, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	u, ok := ds.urls[short]
+	if !ok {
+		return nil, urls.ErrNotFound
+	}
+
+	return copyURL(u), nil
+}
+
+// DeleteURL implements the urls.DataStore interface.
+func (ds *DataStore) DeleteURL(short string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, ok := ds.urls[short]; !ok {
+		return urls.ErrNotFound
+	}
+
+	ds.unindex(ds.urls[short])
+	delete(ds.urls, short)
+	delete(ds.stats, short)
+	delete(ds.logs, short)
+
+	return nil
+}
+
+// PutURL implements the urls.DataStore interface.
+func (ds *DataStore) PutURL(u *urls.URL) (string, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	// We may need to create an ID. Skip any that were taken by an
+	// alias.
+	for u.Short == "" {
+		short := urls.IntToShort(ds.next)
+		ds.next++
+
+		if _, ok := ds.urls[short]; !ok {
+			u.Short = short
+		}
+	}
+
+	if old, ok := ds.urls[u.Short]; ok {
+		ds.unindex(old)
+	}
+	ds.urls[u.Short] = copyURL(u)
+	ds.index(u)
+
+	return u.Short, nil
+}
+
+// CreateURL implements the urls.URLCreator interface.
+func (ds *DataStore) CreateURL(u *urls.URL) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if _, ok := ds.urls[u.Short]; ok {
+		return urls.ErrExists
+	}
+
+	ds.urls[u.Short] = copyURL(u)
+	ds.index(u)
+
+	return nil
+}
+
+// UpdateURL implements the urls.URLUpdater interface.
+func (ds *DataStore) UpdateURL(u *urls.URL, version int) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	old, ok := ds.urls[u.Short]
+	if !ok {
+		return urls.ErrNotFound
+	} else if old.Version != version {
+		return urls.ErrVersion
+	}
+
+	u.Created = old.Created
+	u.Clicks = old.Clicks
+	u.Version = version + 1
+
+	ds.unindex(old)
+	ds.urls[u.Short] = copyURL(u)
+	ds.index(u)
+
+	return nil
+}
+
+// AddClick implements the urls.ClickCounter interface.
+func (ds *DataStore) AddClick(short string) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	u, ok := ds.urls[short]
+	if !ok {
+		return urls.ErrNotFound
+	}
+
+	// We only ever hand out copies, so this is safe.
+	u.Clicks++
+
+	return nil
+}
+
+// FindURL implements the urls.URLFinder interface.
+func (ds *DataStore) FindURL(long string) (*urls.URL, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	for short := range ds.longs[long] {
+		return copyURL(ds.urls[short]), nil
+	}
+
+	return nil, urls.ErrNotFound
+}
+
+// index is a helper function that adds the url to the long url index.
+func (ds *DataStore) index(u *urls.URL) {
+	if ds.longs[u.Long] == nil {
+		ds.longs[u.Long] = make(map[string]bool)
+	}
+	ds.longs[u.Long][u.Short] = true
+}
+
+// unindex is a helper function that removes the url from the long url
+// index.
+func (ds *DataStore) unindex(u *urls.URL) {
+	delete(ds.longs[u.Long], u.Short)
+	if len(ds.longs[u.Long]) == 0 {
+		delete(ds.longs, u.Long)
+	}
+}
+
+// NextID implements the urls.Sequencer interface.
+func (ds *DataStore) NextID() (int64, error) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	n := ds.next
+	ds.next++
+
+	return n, nil
+}
+
+// GetStatistics implements the urls.DataStore interface.
+func (ds *DataStore) GetStatistics(short string) (*urls.Statistics, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	s, ok := ds.stats[short]
+	if !ok {
+		return nil, urls.ErrNotFound
+	}
+
+	return copyStatistics(s), nil
+}
+
+// PutStatistics implements the urls.DataStore interface.
+func (ds *DataStore) PutStatistics(stats *urls.Statistics) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	ds.stats[stats.Short] = copyStatistics(stats)
+
+	return nil
+}
+
+// AddStatistics implements the urls.StatisticsCounter interface.
+func (ds *DataStore) AddStatistics(stats *urls.Statistics) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	// We only ever hand out copies, so this is safe.
+	s, ok := ds.stats[stats.Short]
+	if !ok {
+		s = urls.NewStatistics(stats.Short)
+		ds.stats[stats.Short] = s
+	}
+	s.Add(stats)
+
+	return nil
+}
+
+// LogClick implements the urls.DataStore interface.
+func (ds *DataStore) LogClick(l *urls.Log) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	c := *l
+	ds.logs[l.Short] = append(ds.logs[l.Short], &c)
+
+	return nil
+}
+
+// CountLogs implements the urls.DataStore interface.
+func (ds *DataStore) CountLogs(short string) (int, error) {
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	return len(ds.logs[short]), nil
+}
+
+// GetLogs implements the urls.DataStore interface.
+func (ds *DataStore) GetLogs(short string, limit, offset int) ([]*urls.Log,
+	error) {
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+
+	all := make(byWhen, len(ds.logs[short]))
+	copy(all, ds.logs[short])
+	sort.Stable(all)
+
+	start, end := window(len(all), limit, offset)
+	ls := make([]*urls.Log, 0, end-start)
+	for _, l := range all[start:end] {
+		c := *l
+		ls = append(ls, &c)
+	}
+
+	return ls, nil
+}
+
+// window is a helper function that returns the start and end indexes
+// of a slice of length n for the given limit and offset.
+func window(n, limit, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > n {
+		offset = n
+	}
+
+	end := offset + limit
+	if limit < 0 || end > n {
+		end = n
+	}
+
+	return offset, end
+}
+
+// copyURL returns a copy of the given url so callers can't modify
+// what we are storing.
+func copyURL(u *urls.URL) *urls.URL {
+	c := *u
+	return &c
+}
+
+// copyStatistics returns a deep copy of the given statistics so
+// callers can't modify the maps we are storing.
+func copyStatistics(s *urls.Statistics) *urls.Statistics {
+	c := *s
+	c.Referrers = copyMap(s.Referrers)
+	c.Browsers = copyMap(s.Browsers)
+	c.Countries = copyMap(s.Countries)
+	c.Platforms = copyMap(s.Platforms)
+	c.Devices = copyMap(s.Devices)
+	c.Hours = copyMap(s.Hours)
+	return &c
+}
+
+// copyMap is a helper function for copyStatistics.
+func copyMap(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// These are sort helpers for the url and logs.
+type byCreated []*urls.URL
+
+func (s byCreated) Len() int {
+	return len(s)
+}
+
+func (s byCreated) Less(i, j int) bool {
+	if s[i].Created.Equal(s[j].Created) {
+		return s[i].Short < s[j].Short
+	}
+	return s[i].Created.After(s[j].Created)
+}
+
+func (s byCreated) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+type byWhen []*urls.Log
+
+func (s byWhen) Len() int {
+	return len(s)
+}
+
+func (s byWhen) Less(i, j int) bool {
+	return s[i].When.Before(s[j].When)
+}
+
+func (s byWhen) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,87 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icub3d/urls"
+	"github.com/icub3d/urls/urlstest"
+)
+
+func TestDataStoreSuite(t *testing.T) {
+	urlstest.RunDataStoreSuite(t, func(t *testing.T) urls.DataStore {
+		return NewDataStore()
+	})
+}
+
+func TestCopies(t *testing.T) {
+	ds := NewDataStore()
+
+	u := &urls.URL{Long: "http://example.com/", Created: time.Now()}
+	short, _ := ds.PutURL(u)
+
+	// Changing our copy shouldn't change the stored one.
+	u.Long = "http://changed.com/"
+	got, _ := ds.GetURL(short)
+	if got.Long != "http://example.com/" {
+		t.Errorf("expected stored url to be unchanged but got %v", got.Long)
+	}
+
+	s := urls.NewStatistics(short)
+	ds.PutStatistics(s)
+	s.Browsers["Chrome"] = 1
+	gs, _ := ds.GetStatistics(short)
+	if len(gs.Browsers) != 0 {
+		t.Errorf("expected stored statistics to be unchanged but got %v",
+			gs.Browsers)
+	}
+}
+
+func TestConcurrentRedirect(t *testing.T) {
+	ds := NewDataStore()
+	short, _ := ds.PutURL(&urls.URL{
+		Long:    "http://example.com/",
+		Created: time.Now(),
+	})
+
+	// Start them all at once so they overlap as much as they can.
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for x := 0; x < 50; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "http://localhost/"+short, nil)
+			urls.Redirect(ds, w, r)
+
+			if w.Code != http.StatusFound {
+				t.Errorf("expected %v but got %v", http.StatusFound, w.Code)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if c, _ := ds.CountLogs(short); c != 50 {
+		t.Errorf("expected 50 logs but got %v", c)
+	}
+
+	if u, _ := ds.GetURL(short); u == nil || u.Clicks != 50 {
+		t.Errorf("expected 50 clicks on the url but got %v", u)
+	}
+
+	if s, _ := ds.GetStatistics(short); s == nil || s.Clicks != 50 ||
+		s.Countries["Unknown"] != 50 {
+		t.Errorf("expected 50 clicks in the statistics but got %v", s)
+	}
+}
@@ -22,6 +22,47 @@ type URL struct {
 
 	// The number of clicks this URL has received.
 	Clicks int
+
+	// The date and time this URL stops redirecting. The zero value
+	// means it never does.
+	ExpiresAt time.Time
+
+	// The number of clicks after which this URL stops redirecting. Zero
+	// means there is no limit.
+	MaxClicks int
+
+	// The date and time this URL starts redirecting. Before then, it
+	// shows a coming soon page instead. The zero value means it always
+	// redirects.
+	ActiveFrom time.Time
+
+	// The number of times this URL has been updated. It's used as the
+	// ETag so updates don't overwrite each other.
+	Version int
+
+	// The status code this URL redirects with: 301, 302, 307 or 308.
+	// Zero means the server's default.
+	RedirectStatus int `json:",omitempty"`
+
+	// The bcrypt hash of the password needed to follow this URL. Empty
+	// means there isn't one. It's set with SetPassword and never sent
+	// to clients.
+	PasswordHash string `json:",omitempty"`
+}
+
+// Active returns true if the url has started redirecting.
+func (u *URL) Active(now time.Time) bool {
+	return !now.Before(u.ActiveFrom)
+}
+
+// Expired returns true if the url shouldn't redirect anymore because
+// it's past ExpiresAt or has been clicked MaxClicks times.
+func (u *URL) Expired(now time.Time) bool {
+	if !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt) {
+		return true
+	}
+
+	return u.MaxClicks > 0 && u.Clicks >= u.MaxClicks
 }
 
 // Log is a log of a click.
@@ -32,7 +73,8 @@ type Log struct {
 	// The time the item was clicked.
 	When time.Time
 
-	// The ip address of the request.
+	// The ip address of the client. Redirect uses the server's
+	// ClientResolver to find it (see WithClientResolver).
 	Addr string
 
 	// The referrer of the request.
@@ -70,18 +112,24 @@ type Statistics struct {
 	// clicks without a referrer.
 	Referrers map[string]int
 
-	// A breakdown of the count by browser. 'Unknown' is used for clicks
-	// without a recognizable browser.
+	// A breakdown of the count by browser family (e.g. Chrome or Edge).
+	// 'Unknown' is used for clicks without a recognizable browser.
 	Browsers map[string]int
 
 	// A breakdown of the count by country. 'Unknown' is used for clicks
 	// without a recognizable country.
 	Countries map[string]int
 
-	// A breakdown of the count by platform. 'Unknown' is used for clicks
-	// without a recognizable platform.
+	// A breakdown of the count by platform, which is the operating
+	// system and its version if it's known (e.g. Windows 10 or
+	// Android). 'Unknown' is used for clicks without a recognizable
+	// platform.
 	Platforms map[string]int
 
+	// A breakdown of the count by the kind of device: desktop, mobile,
+	// tablet or bot. 'Unknown' is used for clicks without a user agent.
+	Devices map[string]int
+
 	// A breakdown of the count by Hours. The string is of the form
 	// YYYYMMDDHHMM in 24 hours format.
 	Hours map[string]int
@@ -95,6 +143,35 @@ func NewStatistics(short string) *Statistics {
 		Browsers:  make(map[string]int),
 		Countries: make(map[string]int),
 		Platforms: make(map[string]int),
+		Devices:   make(map[string]int),
 		Hours:     make(map[string]int),
 	}
 }
+
+// Add adds the counts in o to s, creating any maps s doesn't have, and
+// keeps the later of the two LastUpdated times.
+func (s *Statistics) Add(o *Statistics) {
+	s.Clicks += o.Clicks
+	if o.LastUpdated.After(s.LastUpdated) {
+		s.LastUpdated = o.LastUpdated
+	}
+
+	s.Referrers = addCounts(s.Referrers, o.Referrers)
+	s.Browsers = addCounts(s.Browsers, o.Browsers)
+	s.Countries = addCounts(s.Countries, o.Countries)
+	s.Platforms = addCounts(s.Platforms, o.Platforms)
+	s.Devices = addCounts(s.Devices, o.Devices)
+	s.Hours = addCounts(s.Hours, o.Hours)
+}
+
+// addCounts is a helper function for Statistics.Add that adds the
+// counts in o to m and returns it.
+func addCounts(m, o map[string]int) map[string]int {
+	if m == nil {
+		m = make(map[string]int, len(o))
+	}
+	for k, v := range o {
+		m[k] += v
+	}
+	return m
+}
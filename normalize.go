@@ -0,0 +1,129 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// DefaultSchemes are the schemes long urls may use if the server
+// wasn't given any with WithSchemes.
+var DefaultSchemes = []string{"http", "https"}
+
+// defaultPorts are the ports removed from urls with these schemes.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// InvalidURLError is returned by NormalizeURL when a long url can't
+// be used.
+type InvalidURLError struct {
+	URL    string
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *InvalidURLError) Error() string {
+	return fmt.Sprintf("invalid url %q: %v", e.URL, e.Reason)
+}
+
+// WithSchemes sets the schemes long urls may use. They default to
+// DefaultSchemes.
+func WithSchemes(schemes ...string) Option {
+	return func(s *Server) {
+		s.schemes = nil
+		for _, scheme := range schemes {
+			scheme = strings.ToLower(strings.TrimSpace(scheme))
+			if scheme != "" {
+				s.schemes = append(s.schemes, scheme)
+			}
+		}
+	}
+}
+
+// NormalizeURL checks that the given long url is safe to redirect to
+// and returns it in a normal form. It must be absolute, use one of the
+// given schemes (DefaultSchemes if there are none) and have a host.
+// The host is converted to lower case punycode and default ports are
+// removed. If the url can't be used, an *InvalidURLError is returned.
+func NormalizeURL(long string, schemes []string) (string, error) {
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+
+	invalid := func(format string, args ...interface{}) error {
+		return &InvalidURLError{URL: long, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	s := strings.TrimSpace(long)
+	if s == "" {
+		return "", invalid("url is empty")
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", invalid("url can't be parsed")
+	}
+
+	if u.Scheme == "" {
+		return "", invalid("url must be absolute")
+	}
+
+	allowed := false
+	for _, scheme := range schemes {
+		allowed = allowed || u.Scheme == scheme
+	}
+	if !allowed {
+		return "", invalid("scheme %q isn't allowed", u.Scheme)
+	}
+
+	if u.Opaque != "" || u.Hostname() == "" {
+		return "", invalid("host is required")
+	}
+
+	host := u.Hostname()
+	if net.ParseIP(host) == nil {
+		host, err = asciiHost(host)
+		if err != nil {
+			return "", invalid("host %q isn't valid", u.Hostname())
+		}
+	}
+	host = strings.ToLower(host)
+
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		u.Host = "[" + host + "]"
+	} else {
+		u.Host = host
+	}
+
+	return u.String(), nil
+}
+
+// asciiHost is a helper function that converts hosts with non-ASCII
+// characters to punycode. Hosts that are already ASCII are returned
+// as they are, since the IDNA rules would refuse ones that are in use
+// like my_host.example.com or r3---sn-abc.googlevideo.com.
+func asciiHost(host string) (string, error) {
+	for _, r := range host {
+		if r >= utf8.RuneSelf {
+			return idna.Lookup.ToASCII(host)
+		}
+	}
+	return host, nil
+}
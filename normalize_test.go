@@ -0,0 +1,76 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		long     string
+		schemes  []string
+		expected string
+		valid    bool
+	}{
+		// Good ones get normalized.
+		{long: "http://example.com/a?b=c#d", valid: true,
+			expected: "http://example.com/a?b=c#d"},
+		{long: "  HTTPS://Example.COM/Path ", valid: true,
+			expected: "https://example.com/Path"},
+		{long: "http://example.com:80/", valid: true,
+			expected: "http://example.com/"},
+		{long: "https://example.com:443/", valid: true,
+			expected: "https://example.com/"},
+		{long: "http://example.com:443/", valid: true,
+			expected: "http://example.com:443/"},
+		{long: "http://münchen.de/", valid: true,
+			expected: "http://xn--mnchen-3ya.de/"},
+		{long: "http://MÜNCHEN.de:8080/", valid: true,
+			expected: "http://xn--mnchen-3ya.de:8080/"},
+		{long: "http://[::1]:80/", valid: true,
+			expected: "http://[::1]/"},
+		{long: "http://[::1]:8080/", valid: true,
+			expected: "http://[::1]:8080/"},
+		{long: "ftp://example.com/", schemes: []string{"ftp"}, valid: true,
+			expected: "ftp://example.com/"},
+		{long: "http://my_host.example.com/", valid: true,
+			expected: "http://my_host.example.com/"},
+		{long: "https://r3---sn-abc.googlevideo.com/x", valid: true,
+			expected: "https://r3---sn-abc.googlevideo.com/x"},
+
+		// Bad ones don't.
+		{long: ""},
+		{long: "   "},
+		{long: "javascript:alert(1)"},
+		{long: "data:text/html,hi"},
+		{long: "/relative"},
+		{long: "example.com"},
+		{long: "http:example.com"},
+		{long: "http:///path"},
+		{long: "http://exa mple.com/"},
+		{long: "http://example.com:port/"},
+		{long: "ftp://example.com/"},
+		{long: "http://example.com/", schemes: []string{"https"}},
+	}
+
+	for k, test := range tests {
+		n, err := NormalizeURL(test.long, test.schemes)
+		if (err == nil) != test.valid {
+			t.Errorf("Test %v: NormalizeURL(%q) expected valid %v, got %v",
+				k, test.long, test.valid, err)
+			continue
+		}
+
+		if err != nil {
+			if _, ok := err.(*InvalidURLError); !ok {
+				t.Errorf("Test %v: expected *InvalidURLError, got %T", k, err)
+			}
+		} else if n != test.expected {
+			t.Errorf("Test %v: expected NormalizeURL(%q) = %v, got %v",
+				k, test.long, test.expected, n)
+		}
+	}
+}
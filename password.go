@@ -0,0 +1,203 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WithPasswordAttempts sets how many wrong passwords a client can try
+// for a url before it has to wait for the given duration to pass. It
+// defaults to 5 a minute.
+func WithPasswordAttempts(n int, per time.Duration) Option {
+	return func(s *Server) {
+		s.attempts = newLimiter(n, per)
+	}
+}
+
+// defaultAttempts limits the password attempts of servers that don't
+// have their own limiter (including the one used by Redirect).
+var defaultAttempts = newLimiter(5, time.Minute)
+
+// maxPasswordForm is the largest body accepted from the password
+// form.
+const maxPasswordForm = 4096
+
+// SetPassword protects the url with the given password. Users have to
+// enter it before they are redirected. An empty password removes it.
+func (u *URL) SetPassword(password string) error {
+	if password == "" {
+		u.PasswordHash = ""
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password),
+		bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword returns true if the given password unlocks the url.
+// It always returns true for urls without a password.
+func (u *URL) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return true
+	}
+
+	// bcrypt compares the hashes in constant time.
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash),
+		[]byte(password)) == nil
+}
+
+// passwordPage is written for urls with a password until the right
+// one is posted back.
+var passwordPage = template.Must(template.New("password").Parse(
+	`<!DOCTYPE html>
+<html>
+<head><title>Password required</title></head>
+<body>
+<h1>Password required</h1>
+{{if .}}<p>{{.}}</p>
+{{end}}<form method="POST">
+<input type="password" name="password" autofocus>
+<input type="submit" value="Continue">
+</form>
+</body>
+</html>
+`))
+
+// unlock is a helper function that checks the password posted for u.
+// It returns true if the redirect should happen. Otherwise it writes
+// the password form, saying what went wrong if a password was posted.
+func (s *Server) unlock(w http.ResponseWriter, r *http.Request, u *URL) bool {
+	if r.Method == "GET" || r.Method == "HEAD" {
+		writePasswordPage(w, http.StatusOK, "")
+		return false
+	} else if r.Method != "POST" {
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return false
+	}
+
+	attempts := s.attempts
+	if attempts == nil {
+		attempts = defaultAttempts
+	}
+
+	// The attempt is counted before the password is checked so
+	// concurrent requests can't all get in before any of them fail.
+	key := hostOnly(s.clientAddr(r)) + " " + u.Short
+	if wait := attempts.try(key, time.Now()); wait > 0 {
+		w.Header().Set("Retry-After",
+			fmt.Sprintf("%v", int(wait.Seconds()+0.5)))
+		writePasswordPage(w, http.StatusTooManyRequests,
+			"Too many wrong passwords. Please try again later.")
+		return false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPasswordForm)
+	if !u.CheckPassword(r.PostFormValue("password")) {
+		writePasswordPage(w, http.StatusForbidden,
+			"That password isn't right.")
+		return false
+	}
+
+	attempts.reset(key)
+	return true
+}
+
+// writePasswordPage is a helper function that writes the password form
+// with the given status code and message. It isn't cached so the
+// password is asked for every time.
+func writePasswordPage(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	passwordPage.Execute(w, msg)
+}
+
+// hostOnly is a helper function that returns the address without the
+// port so clients are limited no matter which port they use.
+func hostOnly(addr string) string {
+	if ip, ok := parseAddr(addr); ok {
+		return ip.String()
+	}
+	return addr
+}
+
+// limiter counts attempts by key and makes keys with too many wait
+// until the window they started in has passed. Successful attempts
+// should be forgotten with reset.
+type limiter struct {
+	max    int
+	window time.Duration
+
+	mu    sync.Mutex
+	tries map[string]*tries
+	swept time.Time
+}
+
+// tries are the attempts of a key in the current window.
+type tries struct {
+	count int
+	until time.Time
+}
+
+// newLimiter creates a limiter that allows max attempts per window.
+func newLimiter(max int, window time.Duration) *limiter {
+	return &limiter{
+		max:    max,
+		window: window,
+		tries:  make(map[string]*tries),
+	}
+}
+
+// try records an attempt for the key and returns 0 if it's allowed.
+// If the key has already used all of its attempts, nothing is recorded
+// and it returns how long the key has to wait before trying again.
+func (l *limiter) try(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the windows that are over every so often so the map
+	// doesn't grow forever.
+	if now.Sub(l.swept) > l.window {
+		for k, t := range l.tries {
+			if !now.Before(t.until) {
+				delete(l.tries, k)
+			}
+		}
+		l.swept = now
+	}
+
+	t, ok := l.tries[key]
+	if !ok || !now.Before(t.until) {
+		t = &tries{until: now.Add(l.window)}
+		l.tries[key] = t
+	}
+	if t.count >= l.max {
+		return t.until.Sub(now)
+	}
+	t.count++
+	return 0
+}
+
+// reset forgets the attempts of the key.
+func (l *limiter) reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.tries, key)
+}
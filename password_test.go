@@ -0,0 +1,217 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRedirectPassword(t *testing.T) {
+	ds := prep()
+	u := &URL{Short: "secret", Long: "http://example.com/internal.html"}
+	if err := u.SetPassword("hunter2"); err != nil {
+		t.Fatalf("SetPassword() failed: %v", err)
+	}
+	ds.PutURL(u)
+
+	s := NewServer(ds, WithPasswordAttempts(2, time.Minute))
+
+	tests := []struct {
+		method   string
+		password string
+		addr     string
+		code     int
+		location string
+		clicks   int
+	}{
+		// The form is shown first.
+		{method: "GET", code: http.StatusOK},
+
+		// Wrong passwords are limited per client.
+		{method: "POST", password: "wrong", code: http.StatusForbidden},
+		{method: "POST", password: "", code: http.StatusForbidden},
+		{method: "POST", password: "hunter2",
+			code: http.StatusTooManyRequests},
+		{method: "POST", password: "hunter2", addr: "10.0.0.2:1234",
+			code: http.StatusSeeOther, location: u.Long, clicks: 1},
+
+		// Other methods aren't allowed.
+		{method: "PUT", code: http.StatusMethodNotAllowed, clicks: 1},
+	}
+
+	for k, test := range tests {
+		form := url.Values{"password": {test.password}}
+		r, _ := http.NewRequest(test.method, "http://localhost/secret",
+			strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "10.0.0.1:1234"
+		if test.addr != "" {
+			r.RemoteAddr = test.addr
+		}
+		w := httptest.NewRecorder()
+
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+
+		if l := w.Header().Get("Location"); l != test.location {
+			t.Errorf("Test %v: expected Location %q, got %q",
+				k, test.location, l)
+		}
+
+		if c := len(ds.logs["secret"]); c != test.clicks {
+			t.Errorf("Test %v: expected %v clicks logged, got %v",
+				k, test.clicks, c)
+		}
+
+		if test.code == http.StatusOK || test.code == http.StatusForbidden {
+			if !strings.Contains(w.Body.String(), `name="password"`) {
+				t.Errorf("Test %v: expected the password form, got %v",
+					k, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestNewURLPassword(t *testing.T) {
+	ds := prep()
+
+	var b bytes.Buffer
+	b.Write([]byte(`{"Long":"http://example.com/","Password":"hunter2"}`))
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://localhost/urls", &b)
+
+	NewURL(ds, w, r)
+
+	if strings.Contains(w.Body.String(), "hunter2") ||
+		strings.Contains(w.Body.String(), "PasswordHash") {
+		t.Errorf("expected the password to be left out, got %v",
+			w.Body.String())
+	}
+
+	var resp struct {
+		Short     string
+		Protected bool
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil ||
+		!resp.Protected {
+		t.Fatalf("expected a protected url, got %v", w.Body.String())
+	}
+
+	u, _ := ds.GetURL(resp.Short)
+	if u == nil || !u.CheckPassword("hunter2") || u.CheckPassword("wrong") {
+		t.Errorf("expected the password to be stored, got %v", u)
+	}
+
+	// It shouldn't show up in the list either.
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "http://localhost/urls?offset=0&limit=100",
+		nil)
+	GetURLs(ds, w, r)
+	if strings.Contains(w.Body.String(), "PasswordHash") {
+		t.Errorf("expected the password hash to be left out of the list")
+	}
+
+	// A PATCH without a password keeps it and an empty one removes it.
+	for k, test := range []struct {
+		body      string
+		protected bool
+	}{
+		{body: `{"MaxClicks":5}`, protected: true},
+		{body: `{"Password":""}`, protected: false},
+	} {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PATCH", "http://localhost/urls/"+resp.Short,
+			strings.NewReader(test.body))
+		r.Header.Set("If-Match", "*")
+		UpdateURL(ds, w, r)
+
+		u, _ := ds.GetURL(resp.Short)
+		if w.Code != http.StatusOK || (u.PasswordHash != "") != test.protected {
+			t.Errorf("Test %v: expected protected %v, got %v %v",
+				k, test.protected, w.Code, u)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(2, time.Minute)
+	now := time.Now()
+
+	if l.try("a", now) != 0 || l.try("a", now) != 0 {
+		t.Errorf("expected a to be able to try twice")
+	}
+
+	if w := l.try("a", now.Add(time.Second)); w != 59*time.Second {
+		t.Errorf("expected a to wait 59s after two tries, got %v", w)
+	}
+
+	if l.try("b", now) != 0 {
+		t.Errorf("expected b to be able to try")
+	}
+
+	if l.try("a", now.Add(time.Minute)) != 0 {
+		t.Errorf("expected a to be able to try after the window")
+	}
+
+	l.try("a", now.Add(time.Minute))
+	l.reset("a")
+	if l.try("a", now.Add(time.Minute)) != 0 {
+		t.Errorf("expected a to be able to try after a reset")
+	}
+}
+
+func TestRedirectPasswordConcurrent(t *testing.T) {
+	ds := prep()
+	u := &URL{Short: "secret", Long: "http://example.com/internal.html"}
+	if err := u.SetPassword("hunter2"); err != nil {
+		t.Fatalf("SetPassword() failed: %v", err)
+	}
+	ds.PutURL(u)
+
+	s := NewServer(ds, WithPasswordAttempts(3, time.Minute))
+
+	// Only three of the wrong passwords should be checked no matter
+	// how many are sent at once.
+	const n = 40
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for x := 0; x < n; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			form := url.Values{"password": {"wrong"}}
+			r, _ := http.NewRequest("POST", "http://localhost/secret",
+				strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.RemoteAddr = "10.0.0.1:1234"
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	count := map[int]int{}
+	for code := range codes {
+		count[code]++
+	}
+	if count[http.StatusForbidden] != 3 ||
+		count[http.StatusTooManyRequests] != n-3 {
+		t.Errorf("expected 3 forbidden and %v too many requests, got %v",
+			n-3, count)
+	}
+}
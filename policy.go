@@ -0,0 +1,193 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DestinationPolicy decides where users can be sent. It's checked when
+// a url is created and again on every redirect, so urls to
+// destinations that are blocked later stop working.
+type DestinationPolicy interface {
+	// Allowed returns true if users can be sent to the given long url.
+	Allowed(long *url.URL) bool
+}
+
+// WithDestinationPolicy checks long urls with the given policy.
+func WithDestinationPolicy(p DestinationPolicy) Option {
+	return func(s *Server) {
+		s.policy = p
+	}
+}
+
+// WithBlockedStatus sets the status code of redirects to blocked
+// destinations. It defaults to 410 Gone. 451 Unavailable For Legal
+// Reasons is the other likely choice. Codes that aren't 3xx, 4xx or
+// 5xx are ignored.
+func WithBlockedStatus(code int) Option {
+	return func(s *Server) {
+		if code >= 300 && code <= 599 {
+			s.blockedStatus = code
+		}
+	}
+}
+
+// blockedPage is written instead of redirecting to a blocked
+// destination.
+const blockedPage = `<!DOCTYPE html>
+<html>
+<head><title>Link disabled</title></head>
+<body>
+<h1>Link disabled</h1>
+<p>The destination of this link has been blocked.</p>
+</body>
+</html>
+`
+
+// allowed is a helper function that returns true if the server's
+// policy allows the given long url. Long urls that can't be parsed
+// aren't allowed.
+func (s *Server) allowed(long string) bool {
+	if s.policy == nil {
+		return true
+	}
+
+	l, err := url.Parse(long)
+	return err == nil && s.policy.Allowed(l)
+}
+
+// writeBlocked is a helper function that writes the page for a
+// redirect to a blocked destination.
+func (s *Server) writeBlocked(w http.ResponseWriter) {
+	code := s.blockedStatus
+	if code == 0 {
+		code = http.StatusGone
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write([]byte(blockedPage))
+}
+
+// checkDestination is a helper function that returns an
+// *InvalidURLError if users can't be sent to the long url of u.
+func (s *Server) checkDestination(ds DataStore, u *URL) error {
+	if !s.allowed(u.Long) {
+		return &InvalidURLError{URL: u.Long,
+			Reason: "destination isn't allowed"}
+	}
+
+	return s.checkChain(ds, u)
+}
+
+// DomainPolicy is a DestinationPolicy that checks the host of the long
+// url against lists of domains. A domain matches itself and a domain
+// like "*.example.com" matches any subdomain of example.com (but not
+// example.com).
+type DomainPolicy struct {
+	// Hosts that match any of these are blocked.
+	Block []string
+
+	// If there are any, only hosts that match one of these (and none
+	// of Block) are allowed.
+	Allow []string
+}
+
+// LoadDomainPolicy reads a DomainPolicy from the given file. Each line
+// is "allow" or "block" followed by a domain. Blank lines and lines
+// starting with # are ignored.
+//
+//	# Only our sites, but not the user content.
+//	allow example.com
+//	allow *.example.com
+//	block *.users.example.com
+func LoadDomainPolicy(path string) (*DomainPolicy, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := ReadDomainPolicy(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return p, nil
+}
+
+// ReadDomainPolicy reads a DomainPolicy in the format described by
+// LoadDomainPolicy from r.
+func ReadDomainPolicy(r io.Reader) (*DomainPolicy, error) {
+	p := &DomainPolicy{}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %v: expected a rule and a domain", n)
+		}
+
+		switch fields[0] {
+		case "allow":
+			p.Allow = append(p.Allow, fields[1])
+		case "block":
+			p.Block = append(p.Block, fields[1])
+		default:
+			return nil, fmt.Errorf("line %v: unknown rule %q", n, fields[0])
+		}
+	}
+
+	return p, s.Err()
+}
+
+// Allowed implements the DestinationPolicy interface.
+func (p *DomainPolicy) Allowed(long *url.URL) bool {
+	host := normalizeHost(long.Hostname())
+
+	if matchDomain(host, p.Block) {
+		return false
+	}
+
+	return len(p.Allow) == 0 || matchDomain(host, p.Allow)
+}
+
+// matchDomain is a helper function that returns true if the host
+// matches any of the domains.
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if strings.HasPrefix(d, "*.") {
+			if strings.HasSuffix(host, normalizeHost(d[1:])) {
+				return true
+			}
+		} else if host == normalizeHost(d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeHost is a helper function that returns the host in lower
+// case punycode without a trailing dot so it can be compared.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(host, ".")
+	if a, err := asciiHost(host); err == nil {
+		host = a
+	}
+	return strings.ToLower(host)
+}
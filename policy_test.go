@@ -0,0 +1,149 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDomainPolicy(t *testing.T) {
+	p, err := LoadDomainPolicy("testdata/domains.txt")
+	if err != nil {
+		t.Fatalf("LoadDomainPolicy() failed: %v", err)
+	}
+
+	tests := []struct {
+		long    string
+		allowed bool
+	}{
+		{long: "http://example.com/", allowed: true},
+		{long: "http://EXAMPLE.com./", allowed: true},
+		{long: "https://www.example.com:8080/a", allowed: true},
+		{long: "http://a.b.example.com/", allowed: true},
+		{long: "http://xn--bcher-kva.de/", allowed: true},
+		{long: "http://www.bücher.de/", allowed: false},
+		{long: "http://users.example.com/", allowed: true},
+		{long: "http://bob.users.example.com/", allowed: false},
+		{long: "http://BOB.Users.Example.com/", allowed: false},
+		{long: "http://badexample.com/", allowed: false},
+		{long: "http://example.com.evil.net/", allowed: false},
+		{long: "http://evil.net/", allowed: false},
+	}
+
+	for k, test := range tests {
+		l, _ := url.Parse(test.long)
+		if a := p.Allowed(l); a != test.allowed {
+			t.Errorf("Test %v: expected Allowed(%v) = %v, got %v",
+				k, test.long, test.allowed, a)
+		}
+	}
+
+	// Without an allow list, everything but the blocked ones is ok.
+	p = &DomainPolicy{Block: []string{"evil.net", "*.evil.net"}}
+	for k, test := range []struct {
+		long    string
+		allowed bool
+	}{
+		{long: "http://example.com/", allowed: true},
+		{long: "http://evil.net/", allowed: false},
+		{long: "http://www.evil.net/", allowed: false},
+	} {
+		l, _ := url.Parse(test.long)
+		if a := p.Allowed(l); a != test.allowed {
+			t.Errorf("Test %v: expected Allowed(%v) = %v, got %v",
+				k, test.long, test.allowed, a)
+		}
+	}
+}
+
+func TestReadDomainPolicy(t *testing.T) {
+	tests := []struct {
+		data  string
+		valid bool
+	}{
+		{data: "", valid: true},
+		{data: "# comment\n\nallow a.com\nblock b.com\n", valid: true},
+		{data: "allow", valid: false},
+		{data: "allow a.com b.com", valid: false},
+		{data: "deny a.com", valid: false},
+	}
+
+	for k, test := range tests {
+		_, err := ReadDomainPolicy(strings.NewReader(test.data))
+		if (err == nil) != test.valid {
+			t.Errorf("Test %v: expected valid %v, got %v", k, test.valid, err)
+		}
+	}
+}
+
+func TestServerDestinationPolicy(t *testing.T) {
+	ds := prep()
+	ds.PutURL(&URL{Short: "evil", Long: "http://evil.net/phish"})
+	policy := &DomainPolicy{Block: []string{"evil.net"}}
+
+	tests := []struct {
+		opts   []Option
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		// Test creating urls.
+		{method: "POST", path: "/api/urls", code: http.StatusOK,
+			body: `{"Long":"http://evil.net/"}`},
+		{opts: []Option{WithDestinationPolicy(policy)},
+			method: "POST", path: "/api/urls", code: http.StatusBadRequest,
+			body: `{"Long":"http://evil.net/"}`},
+		{opts: []Option{WithDestinationPolicy(policy)},
+			method: "POST", path: "/api/urls", code: http.StatusOK,
+			body: `{"Long":"http://example.com/"}`},
+
+		// Test redirects.
+		{method: "GET", path: "/evil", code: http.StatusFound},
+		{opts: []Option{WithDestinationPolicy(policy)},
+			method: "GET", path: "/evil", code: http.StatusGone},
+		{opts: []Option{WithDestinationPolicy(policy),
+			WithBlockedStatus(http.StatusUnavailableForLegalReasons)},
+			method: "GET", path: "/evil",
+			code: http.StatusUnavailableForLegalReasons},
+		{opts: []Option{WithDestinationPolicy(policy),
+			WithBlockedStatus(http.StatusOK)},
+			method: "GET", path: "/evil", code: http.StatusGone},
+		{opts: []Option{WithDestinationPolicy(policy),
+			WithBlockedStatus(600)},
+			method: "GET", path: "/evil", code: http.StatusGone},
+		{opts: []Option{WithDestinationPolicy(policy)},
+			method: "GET", path: "/1c", code: http.StatusFound},
+	}
+
+	for k, test := range tests {
+		s := NewServer(ds, test.opts...)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://localhost"+test.path,
+			bytes.NewBufferString(test.body))
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: %v %v: expected code %v, got %v",
+				k, test.method, test.path, test.code, w.Code)
+		}
+
+		if w.Code >= 400 && w.Code != http.StatusBadRequest {
+			if loc := w.Header().Get("Location"); loc != "" {
+				t.Errorf("Test %v: expected no Location, got %v", k, loc)
+			}
+			if !strings.Contains(w.Body.String(), "Link disabled") {
+				t.Errorf("Test %v: expected the blocked page, got %v",
+					k, w.Body.String())
+			}
+		}
+	}
+}
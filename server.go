@@ -0,0 +1,201 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Server is an http.Handler that routes requests to the handlers in
+// this package. The API lives under the API prefix (/api by default)
+// and everything else is treated as a short id to redirect.
+//
+//	GET    /api/urls         GetURLs
+//	POST   /api/urls         NewURL
+//	GET    /api/urls/{id}    GetURL
+//	PUT    /api/urls/{id}    UpdateURL
+//	PATCH  /api/urls/{id}    UpdateURL
+//	DELETE /api/urls/{id}    DeleteURL
+//	GET    /api/count/urls   CountURLs
+//	GET    /api/stats/{id}   GetStatistics
+//	GET    /{id}             Redirect
+//	POST   /{id}             Redirect (password form, 307 and 308)
+//	PUT    /{id}             Redirect (307 and 308)
+//	PATCH  /{id}             Redirect (307 and 308)
+//	DELETE /{id}             Redirect (307 and 308)
+//
+// Requests with a method a path doesn't support get a 405 Method Not
+// Allowed with the Allow header set. If the server has an
+// Authenticator, it's used to protect everything under the API
+// prefix.
+type Server struct {
+	ds      DataStore
+	prefix  string
+	auth    Authenticator
+	ids     IDGenerator
+	codec   *Codec
+	schemes []string
+	dedupe  bool
+
+	domains  map[string]bool
+	maxChain int
+
+	policy        DestinationPolicy
+	blockedStatus int
+
+	comingSoon http.Handler
+
+	attempts *limiter
+
+	redirectStatus int
+	redirectMaxAge time.Duration
+
+	geo     GeoResolver
+	clients ClientResolver
+	agents  *UAParser
+
+	mux *http.ServeMux
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithAPIPrefix sets the path the API is served under. It defaults to
+// /api. An empty prefix is ignored.
+func WithAPIPrefix(prefix string) Option {
+	return func(s *Server) {
+		if p := strings.Trim(prefix, "/"); p != "" {
+			s.prefix = "/" + p
+		}
+	}
+}
+
+// reserved is a helper function that returns true if the given id is
+// the first part of the API prefix. Requests for it go to the API, so
+// it can't be a short id.
+func (s *Server) reserved(id string) bool {
+	first := strings.SplitN(strings.TrimPrefix(s.prefix, "/"), "/", 2)[0]
+	return first != "" && id == s.idCodec().Normalize(first)
+}
+
+// WithDeduplication makes NewURL return the existing url for a long
+// url instead of creating another one. Only new urls without an alias
+// are deduplicated and the datastore must implement URLFinder. Two
+// requests for the same long url at the same time may still create
+// two urls.
+func WithDeduplication() Option {
+	return func(s *Server) {
+		s.dedupe = true
+	}
+}
+
+// NewServer creates a new Server that uses the given datastore.
+func NewServer(ds DataStore, opts ...Option) *Server {
+	s := &Server{
+		ds:     ds,
+		prefix: "/api",
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if _, ok := ds.(URLFinder); s.dedupe && !ok {
+		log.Printf("datastore doesn't implement URLFinder, " +
+			"not deduplicating urls")
+	}
+
+	s.mux = http.NewServeMux()
+	s.handle(s.prefix+"/urls", methods{
+		"GET":  GetURLs,
+		"POST": s.newURL,
+	})
+	s.handle(s.prefix+"/urls/", methods{
+		"GET":    s.getURL,
+		"PUT":    s.updateURL,
+		"PATCH":  s.updateURL,
+		"DELETE": s.deleteURL,
+	})
+	s.handle(s.prefix+"/count/urls", methods{
+		"GET": CountURLs,
+	})
+	s.handle(s.prefix+"/stats/", methods{
+		"GET": s.getStatistics,
+	})
+	s.mux.Handle(s.prefix+"/", s.authenticate(http.HandlerFunc(notFound)))
+	s.handle("/", methods{
+		"GET":    s.redirect,
+		"POST":   s.redirect,
+		"PUT":    s.redirect,
+		"PATCH":  s.redirect,
+		"DELETE": s.redirect,
+	})
+
+	return s
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// methods maps a request method to the handler for it.
+type methods map[string]HandlerFunc
+
+// allow returns the value of the Allow header for the methods.
+func (m methods) allow() string {
+	ms := make([]string, 0, len(m))
+	for k := range m {
+		ms = append(ms, k)
+	}
+	sort.Strings(ms)
+	return strings.Join(ms, ", ")
+}
+
+// handle is a helper function that registers a handler for the
+// pattern that calls the handler for the request method. HEAD is
+// handled by the GET handler if there is one (net/http drops the
+// body). If the pattern ends in a slash, the rest of the path must be
+// a single valid short id. API patterns are authenticated.
+func (s *Server) handle(pattern string, m methods) {
+	if _, ok := m["HEAD"]; !ok && m["GET"] != nil {
+		m["HEAD"] = m["GET"]
+	}
+
+	allow := m.allow()
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(pattern, "/") &&
+			!s.idCodec().Valid(strings.TrimPrefix(r.URL.Path, pattern)) {
+			notFound(w, r)
+			return
+		}
+
+		f, ok := m[r.Method]
+		if !ok {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("method not allowed"))
+			return
+		}
+
+		f(s.ds, w, r)
+	})
+
+	if strings.HasPrefix(pattern, s.prefix+"/") {
+		s.mux.Handle(pattern, s.authenticate(h))
+	} else {
+		s.mux.Handle(pattern, h)
+	}
+}
+
+// notFound writes a not found response.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("not found"))
+}
@@ -0,0 +1,240 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+		allow  string
+	}{
+		// Test the redirect.
+		{method: "GET", path: "/1c", code: http.StatusFound},
+		{method: "HEAD", path: "/1c", code: http.StatusFound},
+		{method: "POST", path: "/1c", code: http.StatusMethodNotAllowed,
+			allow: "GET, HEAD"},
+		{method: "GET", path: "/", code: http.StatusNotFound},
+		{method: "GET", path: "/1c/extra", code: http.StatusNotFound},
+
+		// Test the urls.
+		{method: "GET", path: "/api/urls", code: http.StatusOK},
+		{method: "HEAD", path: "/api/urls", code: http.StatusOK},
+		{method: "POST", path: "/api/urls", code: http.StatusOK,
+			body: `{"Long":"http://example.com/"}`},
+		{method: "PUT", path: "/api/urls", code: http.StatusMethodNotAllowed,
+			allow: "GET, HEAD, POST"},
+		{method: "DELETE", path: "/api/urls/1d", code: http.StatusOK},
+		{method: "GET", path: "/api/urls/1d", code: http.StatusNotFound},
+		{method: "GET", path: "/api/urls/1e", code: http.StatusOK},
+		{method: "HEAD", path: "/api/urls/1e", code: http.StatusOK},
+		{method: "PATCH", path: "/api/urls/1e", code: http.StatusOK,
+			body: `{"MaxClicks":5,"Version":0}`},
+		{method: "POST", path: "/api/urls/1e", code: http.StatusMethodNotAllowed,
+			allow: "DELETE, GET, HEAD, PATCH, PUT"},
+		{method: "DELETE", path: "/api/urls/", code: http.StatusNotFound},
+
+		// Test the others.
+		{method: "GET", path: "/api/count/urls", code: http.StatusOK},
+		{method: "DELETE", path: "/api/count/urls",
+			code: http.StatusMethodNotAllowed, allow: "GET, HEAD"},
+		{method: "GET", path: "/api/stats/1c", code: http.StatusOK},
+		{method: "HEAD", path: "/api/stats/1c", code: http.StatusOK},
+		{method: "GET", path: "/api/unknown", code: http.StatusNotFound},
+	}
+
+	s := NewServer(prep())
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://localhost"+test.path,
+			bytes.NewBufferString(test.body))
+
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: %v %v: expected code %v, got %v",
+				k, test.method, test.path, test.code, w.Code)
+		}
+
+		if allow := w.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("Test %v: %v %v: expected Allow %q, got %q",
+				k, test.method, test.path, test.allow, allow)
+		}
+	}
+}
+
+func TestServerAPIPrefix(t *testing.T) {
+	s := NewServer(prep(), WithAPIPrefix("/admin/api/"))
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "http://localhost/admin/api/count/urls", nil)
+	s.ServeHTTP(w, r)
+
+	if w.Body.String() != `{"count":200}` {
+		t.Errorf("expected count from prefixed api, got %v", w.Body.String())
+	}
+}
+
+func TestServerDeduplication(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		body     string
+		expected string
+	}{
+		// Test an existing long url.
+		{opts: []Option{WithDeduplication()},
+			body:     `{"Long":"http://longurl.com/100.html"}`,
+			expected: "1c"},
+
+		// Test a new one.
+		{opts: []Option{WithDeduplication()},
+			body:     `{"Long":"http://example.com/"}`,
+			expected: IntToShort(1000)},
+
+		// Test an alias.
+		{opts: []Option{WithDeduplication()},
+			body:     `{"Long":"http://longurl.com/100.html","Alias":"dup"}`,
+			expected: "dup"},
+
+		// Test different limits.
+		{opts: []Option{WithDeduplication()},
+			body:     `{"Long":"http://longurl.com/100.html","MaxClicks":5}`,
+			expected: IntToShort(1000)},
+
+		// Test without deduplication.
+		{body: `{"Long":"http://longurl.com/100.html"}`,
+			expected: IntToShort(1000)},
+	}
+
+	for k, test := range tests {
+		s := NewServer(prep(), test.opts...)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/api/urls",
+			bytes.NewBufferString(test.body))
+		s.ServeHTTP(w, r)
+
+		u := &URL{}
+		if err := json.Unmarshal(w.Body.Bytes(), u); err != nil {
+			t.Fatalf("Test %v: unmarshaling %v failed: %v",
+				k, w.Body.String(), err)
+		}
+
+		if u.Short != test.expected {
+			t.Errorf("Test %v: expected short %v, got %v",
+				k, test.expected, u.Short)
+		}
+	}
+}
+
+func TestServerCodec(t *testing.T) {
+	s := NewServer(prep(), WithCodec(Base36))
+
+	// Aliases are normalized with the server's codec.
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "http://localhost/api/urls",
+		bytes.NewBufferString(`{"Long":"http://example.com/","Alias":"NeW"}`))
+	s.ServeHTTP(w, r)
+
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"Short":"new"`)) {
+		t.Fatalf("expected alias new, got %v %v", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{method: "GET", url: "http://localhost/NEW", code: http.StatusFound},
+		{method: "GET", url: "http://localhost/api/urls/New", code: http.StatusOK},
+		{method: "GET", url: "http://localhost/api/stats/nEw", code: http.StatusOK},
+		{method: "DELETE", url: "http://localhost/api/urls/NEW", code: http.StatusOK},
+
+		// Test an id with characters outside the alphabet.
+		{method: "GET", url: "http://localhost/api/urls/A-", code: http.StatusNotFound},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, test.url, nil)
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+	}
+}
+
+func TestServerReservedIDs(t *testing.T) {
+	tests := []struct {
+		opts     []Option
+		prefix   string
+		count    int
+		body     string
+		code     int
+		expected string
+	}{
+		// Test aliases.
+		{prefix: "/api",
+			body: `{"Long":"http://example.com/","Alias":"api"}`,
+			code: http.StatusBadRequest, expected: `"invalid alias"`},
+		{opts: []Option{WithAPIPrefix("/admin/api")}, prefix: "/admin/api",
+			body: `{"Long":"http://example.com/","Alias":"admin"}`,
+			code: http.StatusBadRequest, expected: `"invalid alias"`},
+		{opts: []Option{WithAPIPrefix("/admin/api")}, prefix: "/admin/api",
+			body: `{"Long":"http://example.com/","Alias":"api"}`,
+			code: http.StatusOK, expected: `"Short":"api"`},
+		{opts: []Option{WithCodec(Base36)}, prefix: "/api",
+			body: `{"Long":"http://example.com/","Alias":"API"}`,
+			code: http.StatusBadRequest, expected: `"invalid alias"`},
+
+		// Test sequential ids (IntToShort(141590) is api).
+		{prefix: "/api", count: 141590,
+			body: `{"Long":"http://example.com/"}`,
+			code: http.StatusOK, expected: `"Short":"apj"`},
+
+		// Test an IDGenerator.
+		{opts: []Option{WithIDGenerator(&fixedIDs{"api", "abc"})},
+			prefix: "/api", body: `{"Long":"http://example.com/"}`,
+			code: http.StatusOK, expected: `"Short":"abc"`},
+	}
+
+	for k, test := range tests {
+		ds := prep()
+		if test.count != 0 {
+			ds.count = test.count
+		}
+		s := NewServer(ds, test.opts...)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost"+test.prefix+"/urls",
+			bytes.NewBufferString(test.body))
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected code %v, got %v", k, test.code, w.Code)
+		}
+
+		if !bytes.Contains(w.Body.Bytes(), []byte(test.expected)) {
+			t.Errorf("Test %v: expected body to contain %v, got %v",
+				k, test.expected, w.Body.String())
+		}
+
+		if test.prefix == "/api" {
+			if u, _ := ds.GetURL("api"); u != nil {
+				t.Errorf("Test %v: expected api not to be stored, got %v", k, u)
+			}
+		}
+	}
+}
@@ -0,0 +1,685 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package sqldb is an implementation of the urls.DataStore that works
+// on top of database/sql. The schema is written for SQLite, but it
+// sticks to plain SQL where it can so other engines shouldn't need
+// much more than a tweak to the migrations.
+package sqldb
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/icub3d/urls"
+)
+
+// migrations is the list of changes to the schema. They are run in
+// order on startup and the index of the last one run is stored in
+// the schema_version table. Only ever append to this list.
+var migrations = [][]string{
+	{
+		`CREATE TABLE urls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short TEXT UNIQUE,
+			long TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			clicks INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX urls_created ON urls (created)`,
+		`CREATE TABLE logs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short TEXT NOT NULL,
+			time TIMESTAMP NOT NULL,
+			addr TEXT NOT NULL,
+			referrer TEXT NOT NULL,
+			user_agent TEXT NOT NULL
+		)`,
+		`CREATE INDEX logs_short_time ON logs (short, time)`,
+		`CREATE TABLE statistics (
+			short TEXT PRIMARY KEY,
+			clicks INTEGER NOT NULL,
+			last_updated TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE statistic_counts (
+			short TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			name TEXT NOT NULL,
+			count INTEGER NOT NULL,
+			PRIMARY KEY (short, kind, name)
+		)`,
+	},
+	{
+		`CREATE TABLE sequence (
+			id INTEGER PRIMARY KEY AUTOINCREMENT
+		)`,
+	},
+	{
+		`CREATE INDEX urls_long ON urls (long)`,
+	},
+	{
+		`ALTER TABLE urls ADD COLUMN expires_at TIMESTAMP`,
+		`ALTER TABLE urls ADD COLUMN max_clicks INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE urls ADD COLUMN active_from TIMESTAMP`,
+	},
+	{
+		`ALTER TABLE urls ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE urls ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`ALTER TABLE urls ADD COLUMN redirect_status INTEGER NOT NULL DEFAULT 0`,
+	},
+}
+
+// urlColumns are the columns of the urls table (other than short) that
+// make up a urls.URL in the order scanURL and urlValues use.
+var urlColumns = []string{"long", "created", "clicks", "expires_at",
+	"max_clicks", "active_from", "version", "password_hash",
+	"redirect_status"}
+
+// These are the statements for the urls table built from urlColumns.
+var (
+	selectURLs = `SELECT short, ` + strings.Join(urlColumns, ", ") +
+		` FROM urls`
+
+	insertURL = `INSERT INTO urls (short, ` + strings.Join(urlColumns, ", ") +
+		`) VALUES (?` + strings.Repeat(", ?", len(urlColumns)) + `)`
+
+	// insertNewURL leaves out the short id so it can be created from
+	// the row id.
+	insertNewURL = `INSERT INTO urls (` + strings.Join(urlColumns, ", ") +
+		`) VALUES (?` + strings.Repeat(", ?", len(urlColumns)-1) + `)`
+
+	updateURL = `UPDATE urls SET ` + strings.Join(urlColumns, " = ?, ") +
+		` = ? WHERE short = ?`
+)
+
+// scanURL is a helper function that scans the short id and urlColumns
+// of a row into a url.
+func scanURL(row interface {
+	Scan(dest ...interface{}) error
+}) (*urls.URL, error) {
+	u := &urls.URL{}
+	var expires, active sql.NullTime
+	err := row.Scan(&u.Short, &u.Long, &u.Created, &u.Clicks, &expires,
+		&u.MaxClicks, &active, &u.Version, &u.PasswordHash,
+		&u.RedirectStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	// NULL is the zero time.
+	u.ExpiresAt = expires.Time
+	u.ActiveFrom = active.Time
+
+	return u, nil
+}
+
+// urlValues is a helper function that returns the values of the
+// urlColumns for the given url.
+func urlValues(u *urls.URL) []interface{} {
+	return []interface{}{u.Long, u.Created.UTC(), u.Clicks,
+		nullTime(u.ExpiresAt), u.MaxClicks, nullTime(u.ActiveFrom), u.Version,
+		u.PasswordHash, u.RedirectStatus}
+}
+
+// nullTime is a helper function that stores the zero time as NULL.
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+// These are the values of the kind column in statistic_counts for
+// each of the maps in urls.Statistics.
+const (
+	kindReferrer = "referrer"
+	kindBrowser  = "browser"
+	kindCountry  = "country"
+	kindPlatform = "platform"
+	kindDevice   = "device"
+	kindHour     = "hour"
+)
+
+// DataStore implements the urls.DataStore interface.
+type DataStore struct {
+	db *sql.DB
+}
+
+// NewDataStore creates a new datastore using the given database. The
+// schema is created or migrated to the newest version if needed.
+func NewDataStore(db *sql.DB) (*DataStore, error) {
+	ds := &DataStore{
+		db: db,
+	}
+
+	if err := ds.migrate(); err != nil {
+		return nil, err
+	}
+
+	return ds, nil
+}
+
+// migrate is a helper function that brings the schema up to date.
+func (ds *DataStore) migrate() error {
+	_, err := ds.db.Exec(
+		`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return err
+	}
+
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	err = tx.QueryRow(`SELECT version FROM schema_version`).Scan(&version)
+	if err == sql.ErrNoRows {
+		if _, err := tx.Exec(
+			`INSERT INTO schema_version (version) VALUES (0)`); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		for _, stmt := range migrations[version] {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE schema_version SET version = ?`,
+		version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountURLs implements the urls.DataStore interface.
+func (ds *DataStore) CountURLs() (int, error) {
+	var c int
+	err := ds.db.QueryRow(`SELECT COUNT(*) FROM urls`).Scan(&c)
+	return c, err
+}
+
+// GetURLs implements the urls.DataStore interface.
+func (ds *DataStore) GetURLs(limit, offset int) ([]*urls.URL, error) {
+	rows, err := ds.db.Query(selectURLs+
+		` ORDER BY created DESC, id DESC LIMIT ? OFFSET ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	us := make([]*urls.URL, 0, limit)
+	for rows.Next() {
+		u, err := scanURL(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		us = append(us, u)
+	}
+
+	return us, rows.Err()
+}
+
+// GetURL implements the urls.DataStore interface.
+func (ds *DataStore) GetURL(short string) (*urls.URL, error) {
+	u, err := scanURL(ds.db.QueryRow(selectURLs+` WHERE short = ?`, short))
+	if err == sql.ErrNoRows {
+		return nil, urls.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// DeleteURL implements the urls.DataStore interface.
+func (ds *DataStore) DeleteURL(short string) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM urls WHERE short = ?`, short)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return urls.ErrNotFound
+	}
+
+	for _, stmt := range []string{
+		`DELETE FROM logs WHERE short = ?`,
+		`DELETE FROM statistics WHERE short = ?`,
+		`DELETE FROM statistic_counts WHERE short = ?`,
+	} {
+		if _, err := tx.Exec(stmt, short); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// PutURL implements the urls.DataStore interface.
+func (ds *DataStore) PutURL(u *urls.URL) (string, error) {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if u.Short != "" {
+		// Try to update an existing one first.
+		res, err := tx.Exec(updateURL, append(urlValues(u), u.Short)...)
+		if err != nil {
+			return "", err
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return "", err
+		}
+
+		if n == 0 {
+			_, err = tx.Exec(insertURL,
+				append([]interface{}{u.Short}, urlValues(u)...)...)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		return u.Short, tx.Commit()
+	}
+
+	// We need to create an ID. We insert without one and then use the
+	// row's id to make the short one. If an alias already took that
+	// one, we throw the row away and try the next.
+	var short string
+	for short == "" {
+		res, err := tx.Exec(insertNewURL, urlValues(u)...)
+		if err != nil {
+			return "", err
+		}
+
+		id, err := res.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+
+		short = urls.IntToShort(id)
+		var taken int
+		err = tx.QueryRow(`SELECT COUNT(*) FROM urls WHERE short = ?`,
+			short).Scan(&taken)
+		if err != nil {
+			return "", err
+		}
+
+		if taken > 0 {
+			short = ""
+			_, err = tx.Exec(`DELETE FROM urls WHERE id = ?`, id)
+		} else {
+			_, err = tx.Exec(`UPDATE urls SET short = ? WHERE id = ?`,
+				short, id)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	u.Short = short
+	return u.Short, nil
+}
+
+// CreateURL implements the urls.URLCreator interface.
+func (ds *DataStore) CreateURL(u *urls.URL) error {
+	// A single insert takes the write lock straight away, so concurrent
+	// creators wait on each other instead of failing to upgrade a read
+	// lock. The short column is unique, so if it fails because the id
+	// is taken we report that.
+	_, err := ds.db.Exec(insertURL,
+		append([]interface{}{u.Short}, urlValues(u)...)...)
+	if err == nil {
+		return nil
+	}
+
+	var taken int
+	if ds.db.QueryRow(`SELECT COUNT(*) FROM urls WHERE short = ?`,
+		u.Short).Scan(&taken) == nil && taken > 0 {
+		return urls.ErrExists
+	}
+
+	return err
+}
+
+// UpdateURL implements the urls.URLUpdater interface.
+func (ds *DataStore) UpdateURL(u *urls.URL, version int) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Claiming the next version first locks the row, so nothing can
+	// change it until we commit.
+	res, err := tx.Exec(
+		`UPDATE urls SET version = version + 1 WHERE short = ? AND version = ?`,
+		u.Short, version)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var c int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM urls WHERE short = ?`,
+			u.Short).Scan(&c)
+		if err != nil {
+			return err
+		} else if c == 0 {
+			return urls.ErrNotFound
+		}
+		return urls.ErrVersion
+	}
+
+	c := *u
+	err = tx.QueryRow(`SELECT created, clicks FROM urls WHERE short = ?`,
+		u.Short).Scan(&c.Created, &c.Clicks)
+	if err != nil {
+		return err
+	}
+	c.Version = version + 1
+
+	if _, err := tx.Exec(updateURL,
+		append(urlValues(&c), c.Short)...); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	*u = c
+	return nil
+}
+
+// AddClick implements the urls.ClickCounter interface.
+func (ds *DataStore) AddClick(short string) error {
+	res, err := ds.db.Exec(`UPDATE urls SET clicks = clicks + 1 WHERE short = ?`,
+		short)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return urls.ErrNotFound
+	}
+
+	return nil
+}
+
+// FindURL implements the urls.URLFinder interface. The oldest url with
+// the long url is returned.
+func (ds *DataStore) FindURL(long string) (*urls.URL, error) {
+	u, err := scanURL(ds.db.QueryRow(selectURLs+
+		` WHERE long = ? AND short IS NOT NULL ORDER BY id LIMIT 1`, long))
+	if err == sql.ErrNoRows {
+		return nil, urls.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// NextID implements the urls.Sequencer interface.
+func (ds *DataStore) NextID() (int64, error) {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// AUTOINCREMENT never reuses an id, so we don't need to keep the
+	// row around.
+	res, err := tx.Exec(`INSERT INTO sequence DEFAULT VALUES`)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sequence WHERE id = ?`, id); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetStatistics implements the urls.DataStore interface.
+func (ds *DataStore) GetStatistics(short string) (*urls.Statistics, error) {
+	stats := urls.NewStatistics(short)
+
+	err := ds.db.QueryRow(
+		`SELECT clicks, last_updated FROM statistics WHERE short = ?`,
+		short).Scan(&stats.Clicks, &stats.LastUpdated)
+	if err == sql.ErrNoRows {
+		return nil, urls.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := ds.db.Query(
+		`SELECT kind, name, count FROM statistic_counts WHERE short = ?`,
+		short)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	maps := statMaps(stats)
+	for rows.Next() {
+		var kind, name string
+		var count int
+		if err := rows.Scan(&kind, &name, &count); err != nil {
+			return nil, err
+		}
+
+		// Ignore kinds we don't know about.
+		if m, ok := maps[kind]; ok {
+			m[name] = count
+		}
+	}
+
+	return stats, rows.Err()
+}
+
+// PutStatistics implements the urls.DataStore interface.
+func (ds *DataStore) PutStatistics(stats *urls.Statistics) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`DELETE FROM statistics WHERE short = ?`,
+		`DELETE FROM statistic_counts WHERE short = ?`,
+	} {
+		if _, err := tx.Exec(stmt, stats.Short); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO statistics (short, clicks, last_updated) VALUES (?, ?, ?)`,
+		stats.Short, stats.Clicks, stats.LastUpdated.UTC())
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(
+		`INSERT INTO statistic_counts (short, kind, name, count)
+		VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for kind, m := range statMaps(stats) {
+		for name, count := range m {
+			if _, err := insert.Exec(stats.Short, kind, name,
+				count); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AddStatistics implements the urls.StatisticsCounter interface. The
+// counts are added in the database so clicks at the same time don't
+// overwrite each other. The upserts need SQLite 3.24 or PostgreSQL
+// 9.5.
+func (ds *DataStore) AddStatistics(stats *urls.Statistics) error {
+	tx, err := ds.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO statistics (short, clicks, last_updated) VALUES (?, ?, ?)
+		ON CONFLICT (short) DO UPDATE SET
+			clicks = clicks + excluded.clicks,
+			last_updated = MAX(last_updated, excluded.last_updated)`,
+		stats.Short, stats.Clicks, stats.LastUpdated.UTC())
+	if err != nil {
+		return err
+	}
+
+	add, err := tx.Prepare(
+		`INSERT INTO statistic_counts (short, kind, name, count)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (short, kind, name) DO UPDATE SET
+			count = count + excluded.count`)
+	if err != nil {
+		return err
+	}
+	defer add.Close()
+
+	for kind, m := range statMaps(stats) {
+		for name, count := range m {
+			if _, err := add.Exec(stats.Short, kind, name,
+				count); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// statMaps is a helper function that maps the statistic_counts kinds
+// to the maps in the given statistics. Nil maps are created.
+func statMaps(stats *urls.Statistics) map[string]map[string]int {
+	if stats.Referrers == nil {
+		stats.Referrers = make(map[string]int)
+	}
+	if stats.Browsers == nil {
+		stats.Browsers = make(map[string]int)
+	}
+	if stats.Countries == nil {
+		stats.Countries = make(map[string]int)
+	}
+	if stats.Platforms == nil {
+		stats.Platforms = make(map[string]int)
+	}
+	if stats.Devices == nil {
+		stats.Devices = make(map[string]int)
+	}
+	if stats.Hours == nil {
+		stats.Hours = make(map[string]int)
+	}
+
+	return map[string]map[string]int{
+		kindReferrer: stats.Referrers,
+		kindBrowser:  stats.Browsers,
+		kindCountry:  stats.Countries,
+		kindPlatform: stats.Platforms,
+		kindDevice:   stats.Devices,
+		kindHour:     stats.Hours,
+	}
+}
+
+// LogClick implements the urls.DataStore interface.
+func (ds *DataStore) LogClick(l *urls.Log) error {
+	_, err := ds.db.Exec(
+		`INSERT INTO logs (short, time, addr, referrer, user_agent)
+		VALUES (?, ?, ?, ?, ?)`,
+		l.Short, l.When.UTC(), l.Addr, l.Referrer, l.UserAgent)
+	return err
+}
+
+// CountLogs implements the urls.DataStore interface.
+func (ds *DataStore) CountLogs(short string) (int, error) {
+	var c int
+	err := ds.db.QueryRow(`SELECT COUNT(*) FROM logs WHERE short = ?`,
+		short).Scan(&c)
+	return c, err
+}
+
+// GetLogs implements the urls.DataStore interface.
+func (ds *DataStore) GetLogs(short string, limit, offset int) ([]*urls.Log,
+	error) {
+
+	rows, err := ds.db.Query(
+		`SELECT short, time, addr, referrer, user_agent FROM logs
+		WHERE short = ? ORDER BY time, id LIMIT ? OFFSET ?`,
+		short, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ls := make([]*urls.Log, 0, limit)
+	for rows.Next() {
+		l := &urls.Log{}
+		if err := rows.Scan(&l.Short, &l.When, &l.Addr, &l.Referrer,
+			&l.UserAgent); err != nil {
+			return nil, err
+		}
+
+		ls = append(ls, l)
+	}
+
+	return ls, rows.Err()
+}
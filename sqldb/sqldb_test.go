@@ -0,0 +1,165 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package sqldb
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icub3d/urls"
+	"github.com/icub3d/urls/urlstest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// open is a helper function that opens a new SQLite database in a
+// temporary directory.
+func open(t *testing.T, name string) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatalf("sql.Open() failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestDataStoreSuite(t *testing.T) {
+	urlstest.RunDataStoreSuite(t, func(t *testing.T) urls.DataStore {
+		ds, err := NewDataStore(open(t, "urls.db"))
+		if err != nil {
+			t.Fatalf("NewDataStore() failed: %v", err)
+		}
+
+		return ds
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	db := open(t, "urls.db")
+
+	ds, err := NewDataStore(db)
+	if err != nil {
+		t.Fatalf("NewDataStore() failed: %v", err)
+	}
+
+	short, err := ds.PutURL(&urls.URL{
+		Long:    "http://example.com/",
+		Created: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("PutURL() failed: %v", err)
+	}
+
+	// Opening it again shouldn't try to recreate anything or lose data.
+	ds, err = NewDataStore(db)
+	if err != nil {
+		t.Fatalf("NewDataStore() failed on existing schema: %v", err)
+	}
+
+	if _, err := ds.GetURL(short); err != nil {
+		t.Errorf("GetURL(%v) failed after migrating again: %v", short, err)
+	}
+
+	var version int
+	db.QueryRow(`SELECT version FROM schema_version`).Scan(&version)
+	if version != len(migrations) {
+		t.Errorf("expected schema version %v, but got %v",
+			len(migrations), version)
+	}
+}
+
+func TestConcurrentRedirect(t *testing.T) {
+	ds, err := NewDataStore(open(t, "urls.db"))
+	if err != nil {
+		t.Fatalf("NewDataStore() failed: %v", err)
+	}
+
+	short, _ := ds.PutURL(&urls.URL{
+		Long:    "http://example.com/",
+		Created: time.Now(),
+	})
+
+	// Start them all at once so they overlap as much as they can.
+	const n = 100
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for x := 0; x < n; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "http://localhost/"+short, nil)
+			urls.Redirect(ds, w, r)
+
+			if w.Code != http.StatusFound {
+				t.Errorf("expected %v but got %v", http.StatusFound, w.Code)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if c, _ := ds.CountLogs(short); c != n {
+		t.Errorf("expected %v logs but got %v", n, c)
+	}
+
+	if u, _ := ds.GetURL(short); u == nil || u.Clicks != n {
+		t.Errorf("expected %v clicks on the url but got %v", n, u)
+	}
+
+	if s, _ := ds.GetStatistics(short); s == nil || s.Clicks != n ||
+		s.Countries["Unknown"] != n {
+		t.Errorf("expected %v clicks in the statistics but got %v", n, s)
+	}
+}
+
+func TestConcurrentCreateURL(t *testing.T) {
+	ds, err := NewDataStore(open(t, "urls.db"))
+	if err != nil {
+		t.Fatalf("NewDataStore() failed: %v", err)
+	}
+
+	// Start them all at once so they overlap as much as they can.
+	const n = 20
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, n)
+	for x := 0; x < n; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			errs <- ds.CreateURL(&urls.URL{
+				Short:   "alias",
+				Long:    "http://example.com/",
+				Created: time.Now(),
+			})
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		if err == nil {
+			created++
+		} else if err != urls.ErrExists {
+			t.Errorf("expected %v but got %v", urls.ErrExists, err)
+		}
+	}
+
+	if created != 1 {
+		t.Errorf("expected 1 url created but got %v", created)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// redirectStatuses are the status codes urls can redirect with.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+// WithRedirectStatus sets the status code of redirects for urls that
+// don't have their own RedirectStatus. It must be 301, 302, 307 or 308
+// and defaults to 302 Found. Other codes are ignored.
+func WithRedirectStatus(code int) Option {
+	return func(s *Server) {
+		if redirectStatuses[code] {
+			s.redirectStatus = code
+		}
+	}
+}
+
+// WithRedirectMaxAge lets browsers and proxies cache permanent (301
+// and 308) redirects for the given duration. Clicks on cached
+// redirects never reach the server, so they aren't logged, and changes
+// to the url (or it being deleted or blocked) aren't seen until the
+// cache expires. Urls with MaxClicks are never cached and ones with
+// ExpiresAt are only cached until then.
+//
+// It defaults to 0, which sends Cache-Control: no-store with every
+// redirect so every click is logged.
+func WithRedirectMaxAge(d time.Duration) Option {
+	return func(s *Server) {
+		s.redirectMaxAge = d
+	}
+}
+
+// statusFor is a helper function that returns the status code to
+// redirect to u with.
+func (s *Server) statusFor(u *URL) int {
+	if u.RedirectStatus != 0 {
+		return u.RedirectStatus
+	} else if s.redirectStatus != 0 {
+		return s.redirectStatus
+	}
+	return http.StatusFound
+}
+
+// keepsMethod returns true if clients repeat the request with the same
+// method and body when they get the given status code.
+func keepsMethod(code int) bool {
+	return code == http.StatusTemporaryRedirect ||
+		code == http.StatusPermanentRedirect
+}
+
+// permanent returns true if the given status code is a permanent
+// redirect.
+func permanent(code int) bool {
+	return code == http.StatusMovedPermanently ||
+		code == http.StatusPermanentRedirect
+}
+
+// writeRedirect is a helper function that writes the redirect to u
+// with the given status code.
+func (s *Server) writeRedirect(w http.ResponseWriter, u *URL, code int,
+	now time.Time) {
+
+	age := s.redirectMaxAge
+	if !u.ExpiresAt.IsZero() && u.ExpiresAt.Sub(now) < age {
+		age = u.ExpiresAt.Sub(now)
+	}
+
+	if permanent(code) && u.MaxClicks == 0 && age >= time.Second {
+		w.Header().Set("Cache-Control",
+			fmt.Sprintf("public, max-age=%v", int(age.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	w.Header().Add("Location", u.Long)
+	w.WriteHeader(code)
+}
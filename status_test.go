@@ -0,0 +1,112 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRedirectStatus(t *testing.T) {
+	ds := prep()
+	now := time.Now()
+	ds.PutURL(&URL{Short: "moved", Long: "http://example.com/",
+		RedirectStatus: http.StatusMovedPermanently})
+	ds.PutURL(&URL{Short: "hook", Long: "http://example.com/hook",
+		RedirectStatus: http.StatusTemporaryRedirect})
+	ds.PutURL(&URL{Short: "perm", Long: "http://example.com/",
+		RedirectStatus: http.StatusPermanentRedirect})
+	ds.PutURL(&URL{Short: "soon", Long: "http://example.com/",
+		RedirectStatus: http.StatusPermanentRedirect,
+		ExpiresAt:      now.Add(time.Minute)})
+	ds.PutURL(&URL{Short: "limited", Long: "http://example.com/",
+		RedirectStatus: http.StatusPermanentRedirect, MaxClicks: 100})
+
+	hour := WithRedirectMaxAge(time.Hour)
+	temporary := WithRedirectStatus(http.StatusTemporaryRedirect)
+
+	tests := []struct {
+		opts   []Option
+		method string
+		id     string
+		code   int
+		cache  string
+	}{
+		// The default is a 302 that isn't cached.
+		{method: "GET", id: "1c", code: http.StatusFound, cache: "no-store"},
+		{opts: []Option{hour}, method: "GET", id: "1c",
+			code: http.StatusFound, cache: "no-store"},
+		{opts: []Option{WithRedirectStatus(299)}, method: "GET", id: "1c",
+			code: http.StatusFound, cache: "no-store"},
+
+		// Methods other than GET are only redirected by 307 and 308.
+		{method: "POST", id: "1c", code: http.StatusMethodNotAllowed},
+		{opts: []Option{temporary}, method: "POST", id: "1c",
+			code: http.StatusTemporaryRedirect, cache: "no-store"},
+		{method: "DELETE", id: "hook",
+			code: http.StatusTemporaryRedirect, cache: "no-store"},
+		{method: "POST", id: "moved", code: http.StatusMethodNotAllowed},
+
+		// Permanent redirects are only cached if the server allows it.
+		{method: "GET", id: "moved",
+			code: http.StatusMovedPermanently, cache: "no-store"},
+		{opts: []Option{hour}, method: "GET", id: "moved",
+			code: http.StatusMovedPermanently, cache: "public, max-age=3600"},
+		{opts: []Option{hour}, method: "PUT", id: "perm",
+			code: http.StatusPermanentRedirect, cache: "public, max-age=3600"},
+		{opts: []Option{hour}, method: "GET", id: "soon",
+			code: http.StatusPermanentRedirect, cache: "public, max-age=59"},
+		{opts: []Option{hour}, method: "GET", id: "limited",
+			code: http.StatusPermanentRedirect, cache: "no-store"},
+	}
+
+	for k, test := range tests {
+		s := NewServer(ds, test.opts...)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(test.method, "http://localhost/"+test.id, nil)
+		s.ServeHTTP(w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v", k, test.code, w.Code)
+		}
+
+		if c := w.Header().Get("Cache-Control"); c != test.cache {
+			t.Errorf("Test %v: expected Cache-Control %q, got %q",
+				k, test.cache, c)
+		}
+	}
+}
+
+func TestNewURLRedirectStatus(t *testing.T) {
+	ds := prep()
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{body: `{"Long":"http://example.com/","RedirectStatus":308}`,
+			code: http.StatusOK},
+		{body: `{"Long":"http://example.com/","RedirectStatus":303}`,
+			code: http.StatusBadRequest},
+		{body: `{"Long":"http://example.com/","RedirectStatus":200}`,
+			code: http.StatusBadRequest},
+	}
+
+	for k, test := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "http://localhost/urls",
+			bytes.NewBufferString(test.body))
+		NewURL(ds, w, r)
+
+		if w.Code != test.code {
+			t.Errorf("Test %v: expected %v, got %v (%v)",
+				k, test.code, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,735 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+// Package urlstest provides utilities for testing implementations of
+// the urls.DataStore interface.
+package urlstest
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/icub3d/urls"
+)
+
+// Factory returns a new, empty DataStore. It's called once for each
+// test in the suite so tests don't interfere with one another.
+type Factory func(t *testing.T) urls.DataStore
+
+// RunDataStoreSuite runs a set of tests against the DataStores
+// created by the given factory to verify they behave the way the
+// handlers expect them to.
+func RunDataStoreSuite(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		f    func(t *testing.T, ds urls.DataStore)
+	}{
+		{"PutURL", testPutURL},
+		{"PutURLSkipsTaken", testPutURLSkipsTaken},
+		{"CreateURL", testCreateURL},
+		{"NextID", testNextID},
+		{"FindURL", testFindURL},
+		{"UpdateURL", testUpdateURL},
+		{"AddClick", testAddClick},
+		{"GetURL", testGetURL},
+		{"GetURLs", testGetURLs},
+		{"CountURLs", testCountURLs},
+		{"DeleteURL", testDeleteURL},
+		{"Statistics", testStatistics},
+		{"AddStatistics", testAddStatistics},
+		{"Logs", testLogs},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.f(t, factory(t))
+		})
+	}
+}
+
+// base is the time all of the test data is created relative to. It's
+// rounded to the second and in UTC so backends that don't store
+// sub-second precision or time zones still compare equal.
+var base = time.Date(2013, time.August, 1, 12, 0, 0, 0, time.UTC)
+
+// putURLs is a helper function that inserts n urls, each created a
+// minute after the previous one. The inserted urls are returned in
+// the order they were inserted.
+func putURLs(t *testing.T, ds urls.DataStore, n int) []*urls.URL {
+	us := make([]*urls.URL, 0, n)
+	for x := 0; x < n; x++ {
+		u := &urls.URL{
+			Long:    fmt.Sprintf("http://example.com/%v.html", x),
+			Created: base.Add(time.Duration(x) * time.Minute),
+			Clicks:  x,
+		}
+
+		// Some of them expire.
+		if x%2 == 1 {
+			u.ExpiresAt = base.Add(time.Duration(x) * 24 * time.Hour)
+			u.MaxClicks = x * 10
+		}
+
+		// Some of them haven't started yet.
+		if x%3 == 1 {
+			u.ActiveFrom = base.Add(time.Duration(x) * time.Hour)
+		}
+
+		// Some of them have a password. The hash only has to be
+		// stored, not checked.
+		if x%4 == 2 {
+			u.PasswordHash = fmt.Sprintf("$2a$10$%053d", x)
+		}
+
+		// Some of them redirect permanently.
+		if x%5 == 3 {
+			u.RedirectStatus = http.StatusMovedPermanently
+		}
+
+		if _, err := ds.PutURL(u); err != nil {
+			t.Fatalf("PutURL(%v) failed: %v", u, err)
+		}
+
+		us = append(us, u)
+	}
+
+	return us
+}
+
+// equalURL is a helper function that compares urls.
+func equalURL(a, b *urls.URL) bool {
+	return a.Short == b.Short && a.Long == b.Long &&
+		a.Created.Equal(b.Created) && a.Clicks == b.Clicks &&
+		a.ExpiresAt.Equal(b.ExpiresAt) && a.MaxClicks == b.MaxClicks &&
+		a.ActiveFrom.Equal(b.ActiveFrom) && a.Version == b.Version &&
+		a.PasswordHash == b.PasswordHash && a.RedirectStatus == b.RedirectStatus
+}
+
+func testPutURL(t *testing.T, ds urls.DataStore) {
+	us := putURLs(t, ds, 10)
+
+	seen := make(map[string]bool)
+	for k, u := range us {
+		if !urls.ValidID(u.Short) {
+			t.Errorf("Test %v: PutURL assigned invalid short id %q", k, u.Short)
+		}
+
+		if seen[u.Short] {
+			t.Errorf("Test %v: PutURL assigned duplicate short id %q", k, u.Short)
+		}
+		seen[u.Short] = true
+	}
+
+	// Putting an existing url should overwrite it.
+	u := us[3]
+	u.Long = "http://example.com/updated.html"
+	u.Clicks = 100
+	u.ExpiresAt = time.Time{}
+	u.MaxClicks = 0
+	short, err := ds.PutURL(u)
+	if err != nil {
+		t.Fatalf("PutURL(%v) failed on update: %v", u, err)
+	}
+
+	if short != u.Short {
+		t.Errorf("PutURL changed the short id on update: %v -> %v",
+			u.Short, short)
+	}
+
+	got, err := ds.GetURL(u.Short)
+	if err != nil {
+		t.Fatalf("GetURL(%v) failed: %v", u.Short, err)
+	}
+
+	if !equalURL(u, got) {
+		t.Errorf("expected updated url %v, but got %v", u, got)
+	}
+
+	c, err := ds.CountURLs()
+	if err != nil {
+		t.Fatalf("CountURLs() failed: %v", err)
+	}
+
+	if c != 10 {
+		t.Errorf("expected update not to add a url: expecting 10, got %v", c)
+	}
+}
+
+func testPutURLSkipsTaken(t *testing.T, ds urls.DataStore) {
+	// Take the ids a new datastore is likely to hand out first.
+	taken := map[string]bool{}
+	for x := int64(0); x < 5; x++ {
+		u := &urls.URL{
+			Short:   urls.IntToShort(x),
+			Long:    "http://example.com/alias.html",
+			Created: base,
+		}
+
+		if _, err := ds.PutURL(u); err != nil {
+			t.Fatalf("PutURL(%v) failed: %v", u, err)
+		}
+		taken[u.Short] = true
+	}
+
+	for k, u := range putURLs(t, ds, 5) {
+		if taken[u.Short] {
+			t.Errorf("Test %v: PutURL assigned short id %q which was taken",
+				k, u.Short)
+		}
+	}
+
+	for short := range taken {
+		u, err := ds.GetURL(short)
+		if err != nil {
+			t.Errorf("GetURL(%v) failed: %v", short, err)
+		} else if u.Long != "http://example.com/alias.html" {
+			t.Errorf("url %v was overwritten with %v", short, u.Long)
+		}
+	}
+}
+
+func testCreateURL(t *testing.T, ds urls.DataStore) {
+	c, ok := ds.(urls.URLCreator)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.URLCreator")
+	}
+
+	u := &urls.URL{
+		Short:   "launch2013",
+		Long:    "http://example.com/launch.html",
+		Created: base,
+	}
+
+	if err := c.CreateURL(u); err != nil {
+		t.Fatalf("CreateURL(%v) failed: %v", u, err)
+	}
+
+	got, err := ds.GetURL(u.Short)
+	if err != nil {
+		t.Fatalf("GetURL(%v) failed: %v", u.Short, err)
+	}
+
+	if !equalURL(u, got) {
+		t.Errorf("expected %v, but got %v", u, got)
+	}
+
+	dup := &urls.URL{
+		Short:   "launch2013",
+		Long:    "http://example.com/other.html",
+		Created: base,
+	}
+
+	if err := c.CreateURL(dup); err != urls.ErrExists {
+		t.Errorf("expected ErrExists for a duplicate, but got %v", err)
+	}
+
+	got, err = ds.GetURL(u.Short)
+	if err != nil {
+		t.Fatalf("GetURL(%v) failed: %v", u.Short, err)
+	}
+
+	if !equalURL(u, got) {
+		t.Errorf("duplicate changed the url: expected %v, but got %v", u, got)
+	}
+}
+
+func testNextID(t *testing.T, ds urls.DataStore) {
+	seq, ok := ds.(urls.Sequencer)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.Sequencer")
+	}
+
+	var last int64 = -1
+	for k := 0; k < 10; k++ {
+		n, err := seq.NextID()
+		if err != nil {
+			t.Fatalf("Test %v: NextID() failed: %v", k, err)
+		}
+
+		if n <= last {
+			t.Errorf("Test %v: expected NextID() > %v, but got %v", k, last, n)
+		}
+		last = n
+	}
+}
+
+func testFindURL(t *testing.T, ds urls.DataStore) {
+	f, ok := ds.(urls.URLFinder)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.URLFinder")
+	}
+
+	us := putURLs(t, ds, 3)
+	for k, u := range us {
+		got, err := f.FindURL(u.Long)
+		if err != nil {
+			t.Fatalf("Test %v: FindURL(%v) failed: %v", k, u.Long, err)
+		}
+
+		if !equalURL(u, got) {
+			t.Errorf("Test %v: expected %v, but got %v", k, u, got)
+		}
+	}
+
+	missing := "http://example.com/missing.html"
+	if _, err := f.FindURL(missing); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing long url, but got %v", err)
+	}
+
+	// Changing the long url should update the index.
+	old := us[0].Long
+	us[0].Long = "http://example.com/changed.html"
+	if _, err := ds.PutURL(us[0]); err != nil {
+		t.Fatalf("PutURL(%v) failed: %v", us[0], err)
+	}
+
+	if _, err := f.FindURL(old); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for the old long url, but got %v", err)
+	}
+
+	if got, err := f.FindURL(us[0].Long); err != nil || !equalURL(us[0], got) {
+		t.Errorf("expected %v for the new long url, but got %v, %v",
+			us[0], got, err)
+	}
+
+	// With two urls for the same long url, deleting one should leave
+	// the other.
+	dup := &urls.URL{
+		Long:    us[1].Long,
+		Created: base,
+	}
+	if _, err := ds.PutURL(dup); err != nil {
+		t.Fatalf("PutURL(%v) failed: %v", dup, err)
+	}
+
+	if err := ds.DeleteURL(us[1].Short); err != nil {
+		t.Fatalf("DeleteURL(%v) failed: %v", us[1].Short, err)
+	}
+
+	if got, err := f.FindURL(dup.Long); err != nil || !equalURL(dup, got) {
+		t.Errorf("expected %v after deleting the other, but got %v, %v",
+			dup, got, err)
+	}
+
+	if err := ds.DeleteURL(dup.Short); err != nil {
+		t.Fatalf("DeleteURL(%v) failed: %v", dup.Short, err)
+	}
+
+	if _, err := f.FindURL(dup.Long); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound after deleting both, but got %v", err)
+	}
+}
+
+func testUpdateURL(t *testing.T, ds urls.DataStore) {
+	up, ok := ds.(urls.URLUpdater)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.URLUpdater")
+	}
+
+	us := putURLs(t, ds, 2)
+
+	// Created and Clicks should be kept.
+	u := &urls.URL{
+		Short:     us[0].Short,
+		Long:      "http://example.com/updated.html",
+		Created:   base.Add(time.Hour),
+		Clicks:    us[0].Clicks + 10,
+		ExpiresAt: base.Add(48 * time.Hour),
+		MaxClicks: 5,
+	}
+	if err := up.UpdateURL(u, us[0].Version); err != nil {
+		t.Fatalf("UpdateURL(%v, %v) failed: %v", u, us[0].Version, err)
+	}
+
+	expected := *us[0]
+	expected.Long = "http://example.com/updated.html"
+	expected.ExpiresAt = base.Add(48 * time.Hour)
+	expected.MaxClicks = 5
+	expected.ActiveFrom = time.Time{}
+	expected.Version = us[0].Version + 1
+	if !equalURL(&expected, u) {
+		t.Errorf("expected UpdateURL to set %v, but got %v", &expected, u)
+	}
+
+	got, err := ds.GetURL(u.Short)
+	if err != nil {
+		t.Fatalf("GetURL(%v) failed: %v", u.Short, err)
+	}
+
+	if !equalURL(&expected, got) {
+		t.Errorf("expected %v, but got %v", &expected, got)
+	}
+
+	// The old version should now be rejected and change nothing.
+	stale := &urls.URL{
+		Short: us[0].Short,
+		Long:  "http://example.com/stale.html",
+	}
+	if err := up.UpdateURL(stale, us[0].Version); err != urls.ErrVersion {
+		t.Errorf("expected ErrVersion for a stale version, but got %v", err)
+	}
+
+	if got, err := ds.GetURL(u.Short); err != nil || !equalURL(&expected, got) {
+		t.Errorf("stale update changed the url: expected %v, but got %v, %v",
+			&expected, got, err)
+	}
+
+	// The other url shouldn't have changed.
+	if got, err := ds.GetURL(us[1].Short); err != nil || !equalURL(us[1], got) {
+		t.Errorf("expected %v to be unchanged, but got %v, %v",
+			us[1], got, err)
+	}
+
+	missing := &urls.URL{
+		Short: "notfound",
+		Long:  "http://example.com/missing.html",
+	}
+	if err := up.UpdateURL(missing, 0); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing url, but got %v", err)
+	}
+}
+
+func testAddClick(t *testing.T, ds urls.DataStore) {
+	c, ok := ds.(urls.ClickCounter)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.ClickCounter")
+	}
+
+	us := putURLs(t, ds, 2)
+	for k := 0; k < 3; k++ {
+		if err := c.AddClick(us[0].Short); err != nil {
+			t.Fatalf("Test %v: AddClick(%v) failed: %v", k, us[0].Short, err)
+		}
+	}
+
+	expected := *us[0]
+	expected.Clicks += 3
+	got, err := ds.GetURL(us[0].Short)
+	if err != nil || !equalURL(&expected, got) {
+		t.Errorf("expected %v, but got %v, %v", &expected, got, err)
+	}
+
+	if got, err := ds.GetURL(us[1].Short); err != nil || !equalURL(us[1], got) {
+		t.Errorf("expected %v to be unchanged, but got %v, %v",
+			us[1], got, err)
+	}
+
+	if err := c.AddClick("notfound"); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing url, but got %v", err)
+	}
+}
+
+func testGetURL(t *testing.T, ds urls.DataStore) {
+	us := putURLs(t, ds, 3)
+
+	for k, u := range us {
+		got, err := ds.GetURL(u.Short)
+		if err != nil {
+			t.Errorf("Test %v: GetURL(%v) failed: %v", k, u.Short, err)
+			continue
+		}
+
+		if !equalURL(u, got) {
+			t.Errorf("Test %v: expected %v, but got %v", k, u, got)
+		}
+	}
+
+	if _, err := ds.GetURL("notfound"); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for a missing url, but got %v", err)
+	}
+}
+
+func testGetURLs(t *testing.T, ds urls.DataStore) {
+	us := putURLs(t, ds, 25)
+
+	tests := []struct {
+		limit  int
+		offset int
+		start  int
+		end    int
+	}{
+		// Test beginning.
+		{limit: 10, offset: 0, start: 24, end: 14},
+
+		// Test in the middle.
+		{limit: 5, offset: 10, start: 14, end: 9},
+
+		// Test end.
+		{limit: 10, offset: 20, start: 4, end: -1},
+
+		// Test past the end.
+		{limit: 10, offset: 30, start: -1, end: -1},
+	}
+
+	for k, test := range tests {
+		got, err := ds.GetURLs(test.limit, test.offset)
+		if err != nil {
+			t.Errorf("Test %v: GetURLs(%v, %v) failed: %v",
+				k, test.limit, test.offset, err)
+			continue
+		}
+
+		// The newest should come first.
+		expected := []*urls.URL{}
+		for x := test.start; x > test.end; x-- {
+			expected = append(expected, us[x])
+		}
+
+		if len(got) != len(expected) {
+			t.Errorf("Test %v: expected %v urls, but got %v",
+				k, len(expected), len(got))
+			continue
+		}
+
+		for x := range got {
+			if !equalURL(expected[x], got[x]) {
+				t.Errorf("Test %v: url %v: expected %v, but got %v",
+					k, x, expected[x], got[x])
+			}
+		}
+	}
+}
+
+func testCountURLs(t *testing.T, ds urls.DataStore) {
+	c, err := ds.CountURLs()
+	if err != nil {
+		t.Fatalf("CountURLs() failed: %v", err)
+	}
+
+	if c != 0 {
+		t.Errorf("expected an empty datastore, but got %v urls", c)
+	}
+
+	putURLs(t, ds, 7)
+
+	c, err = ds.CountURLs()
+	if err != nil {
+		t.Fatalf("CountURLs() failed: %v", err)
+	}
+
+	if c != 7 {
+		t.Errorf("expected 7 urls, but got %v", c)
+	}
+}
+
+func testDeleteURL(t *testing.T, ds urls.DataStore) {
+	us := putURLs(t, ds, 2)
+	del, keep := us[0], us[1]
+
+	for _, u := range us {
+		if err := ds.PutStatistics(urls.NewStatistics(u.Short)); err != nil {
+			t.Fatalf("PutStatistics(%v) failed: %v", u.Short, err)
+		}
+
+		if err := ds.LogClick(&urls.Log{Short: u.Short, When: base}); err != nil {
+			t.Fatalf("LogClick(%v) failed: %v", u.Short, err)
+		}
+	}
+
+	if err := ds.DeleteURL(del.Short); err != nil {
+		t.Fatalf("DeleteURL(%v) failed: %v", del.Short, err)
+	}
+
+	if _, err := ds.GetURL(del.Short); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for deleted url, but got %v", err)
+	}
+
+	if _, err := ds.GetStatistics(del.Short); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for deleted statistics, but got %v", err)
+	}
+
+	if c, err := ds.CountLogs(del.Short); err != nil || c != 0 {
+		t.Errorf("expected deleted logs to be gone, but got (%v, %v)", c, err)
+	}
+
+	// The other url shouldn't have been touched.
+	if _, err := ds.GetURL(keep.Short); err != nil {
+		t.Errorf("GetURL(%v) failed after deleting another url: %v",
+			keep.Short, err)
+	}
+
+	if c, err := ds.CountLogs(keep.Short); err != nil || c != 1 {
+		t.Errorf("expected other logs to remain, but got (%v, %v)", c, err)
+	}
+
+	if c, err := ds.CountURLs(); err != nil || c != 1 {
+		t.Errorf("expected 1 url after delete, but got (%v, %v)", c, err)
+	}
+}
+
+func testStatistics(t *testing.T, ds urls.DataStore) {
+	u := putURLs(t, ds, 1)[0]
+
+	if _, err := ds.GetStatistics(u.Short); err != urls.ErrNotFound {
+		t.Errorf("expected ErrNotFound for missing statistics, but got %v", err)
+	}
+
+	stats := urls.NewStatistics(u.Short)
+	stats.Clicks = 3
+	stats.LastUpdated = base
+	stats.Referrers["Unknown"] = 2
+	stats.Referrers["www.google.com"] = 1
+	stats.Browsers["Chrome"] = 3
+	stats.Countries["US"] = 3
+	stats.Platforms["Linux"] = 3
+	stats.Devices["desktop"] = 2
+	stats.Devices["mobile"] = 1
+	stats.Hours["201308011200"] = 3
+
+	for k := 0; k < 2; k++ {
+		if err := ds.PutStatistics(stats); err != nil {
+			t.Fatalf("Test %v: PutStatistics(%v) failed: %v", k, stats, err)
+		}
+
+		got, err := ds.GetStatistics(u.Short)
+		if err != nil {
+			t.Fatalf("Test %v: GetStatistics(%v) failed: %v", k, u.Short, err)
+		}
+
+		if got.Short != stats.Short || got.Clicks != stats.Clicks ||
+			!got.LastUpdated.Equal(stats.LastUpdated) ||
+			!reflect.DeepEqual(got.Referrers, stats.Referrers) ||
+			!reflect.DeepEqual(got.Browsers, stats.Browsers) ||
+			!reflect.DeepEqual(got.Countries, stats.Countries) ||
+			!reflect.DeepEqual(got.Platforms, stats.Platforms) ||
+			!reflect.DeepEqual(got.Devices, stats.Devices) ||
+			!reflect.DeepEqual(got.Hours, stats.Hours) {
+			t.Errorf("Test %v: expected statistics %v, but got %v",
+				k, stats, got)
+		}
+
+		// The second time through we should overwrite.
+		stats.Clicks = 4
+		stats.Browsers["Chrome"] = 4
+		delete(stats.Referrers, "Unknown")
+	}
+}
+
+func testAddStatistics(t *testing.T, ds urls.DataStore) {
+	c, ok := ds.(urls.StatisticsCounter)
+	if !ok {
+		t.Skip("DataStore doesn't implement urls.StatisticsCounter")
+	}
+
+	u := putURLs(t, ds, 1)[0]
+
+	click := func(x int) *urls.Statistics {
+		s := urls.NewStatistics(u.Short)
+		s.Clicks = 1
+		s.LastUpdated = base.Add(time.Duration(x) * time.Minute)
+		s.Referrers["Unknown"] = 1
+		s.Browsers[fmt.Sprintf("Browser %v", x%2)] = 1
+		s.Countries["US"] = 1
+		s.Platforms["Linux"] = 1
+		s.Devices["desktop"] = 1
+		s.Hours["201308011200"] = 1
+		return s
+	}
+
+	// The first creates them and the rest are added at once.
+	if err := c.AddStatistics(click(0)); err != nil {
+		t.Fatalf("AddStatistics() failed: %v", err)
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	for x := 1; x < n; x++ {
+		wg.Add(1)
+		go func(x int) {
+			defer wg.Done()
+			if err := c.AddStatistics(click(x)); err != nil {
+				t.Errorf("Test %v: AddStatistics() failed: %v", x, err)
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	expected := urls.NewStatistics(u.Short)
+	for x := 0; x < n; x++ {
+		expected.Add(click(x))
+	}
+
+	got, err := ds.GetStatistics(u.Short)
+	if err != nil {
+		t.Fatalf("GetStatistics(%v) failed: %v", u.Short, err)
+	}
+
+	if got.Short != expected.Short || got.Clicks != expected.Clicks ||
+		!got.LastUpdated.Equal(expected.LastUpdated) ||
+		!reflect.DeepEqual(got.Referrers, expected.Referrers) ||
+		!reflect.DeepEqual(got.Browsers, expected.Browsers) ||
+		!reflect.DeepEqual(got.Countries, expected.Countries) ||
+		!reflect.DeepEqual(got.Platforms, expected.Platforms) ||
+		!reflect.DeepEqual(got.Devices, expected.Devices) ||
+		!reflect.DeepEqual(got.Hours, expected.Hours) {
+		t.Errorf("expected statistics %v, but got %v", expected, got)
+	}
+}
+
+func testLogs(t *testing.T, ds urls.DataStore) {
+	us := putURLs(t, ds, 2)
+	short, other := us[0].Short, us[1].Short
+
+	// Insert them out of order to make sure they are sorted.
+	for _, x := range []int{3, 0, 4, 1, 2} {
+		l := &urls.Log{
+			Short:     short,
+			When:      base.Add(time.Duration(x) * time.Second),
+			Addr:      fmt.Sprintf("10.0.0.%v", x),
+			Referrer:  "www.google.com",
+			UserAgent: "Mozilla/5.0",
+		}
+
+		if err := ds.LogClick(l); err != nil {
+			t.Fatalf("LogClick(%v) failed: %v", l, err)
+		}
+	}
+
+	if err := ds.LogClick(&urls.Log{Short: other, When: base}); err != nil {
+		t.Fatalf("LogClick(%v) failed: %v", other, err)
+	}
+
+	if c, err := ds.CountLogs(short); err != nil || c != 5 {
+		t.Errorf("expected 5 logs, but got (%v, %v)", c, err)
+	}
+
+	tests := []struct {
+		limit  int
+		offset int
+		addrs  []string
+	}{
+		// Test beginning.
+		{limit: 2, offset: 0, addrs: []string{"10.0.0.0", "10.0.0.1"}},
+
+		// Test end.
+		{limit: 10, offset: 3, addrs: []string{"10.0.0.3", "10.0.0.4"}},
+
+		// Test past the end.
+		{limit: 10, offset: 5, addrs: []string{}},
+	}
+
+	for k, test := range tests {
+		ls, err := ds.GetLogs(short, test.limit, test.offset)
+		if err != nil {
+			t.Errorf("Test %v: GetLogs(%v, %v, %v) failed: %v",
+				k, short, test.limit, test.offset, err)
+			continue
+		}
+
+		addrs := make([]string, 0, len(ls))
+		for _, l := range ls {
+			addrs = append(addrs, l.Addr)
+		}
+
+		if !reflect.DeepEqual(addrs, test.addrs) {
+			t.Errorf("Test %v: expected logs %v, but got %v",
+				k, test.addrs, addrs)
+		}
+	}
+}
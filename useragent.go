@@ -0,0 +1,172 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// UserAgent is what a UAParser could tell about the software that made
+// a request. Anything it couldn't tell is "Unknown" or, for versions,
+// empty.
+type UserAgent struct {
+	// The browser family, like Chrome or Edge, and its major version.
+	Browser        string
+	BrowserVersion string
+
+	// The operating system, like Windows or Android, and its version.
+	OS        string
+	OSVersion string
+
+	// The kind of device: desktop, mobile, tablet or bot.
+	Device string
+}
+
+// Platform returns the operating system and its version, like
+// "Windows 7".
+func (a UserAgent) Platform() string {
+	if a.OSVersion == "" {
+		return a.OS
+	}
+	return a.OS + " " + a.OSVersion
+}
+
+// WithUserAgentParser uses the given UAParser to parse the user agents
+// of clicks for the statistics. It defaults to DefaultUAParser.
+func WithUserAgentParser(p *UAParser) Option {
+	return func(s *Server) {
+		s.agents = p
+	}
+}
+
+//go:embed useragents.txt
+var defaultUARules string
+
+// DefaultUAParser parses user agents with the rules in useragents.txt
+// in this package.
+var DefaultUAParser = mustUAParser(defaultUARules)
+
+// mustUAParser is a helper function that creates a UAParser from the
+// given rules and panics if it can't.
+func mustUAParser(rules string) *UAParser {
+	p, err := ReadUAParser(strings.NewReader(rules))
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// UAParser parses user agents with a list of rules. See useragents.txt
+// in this package for the format and the rules DefaultUAParser uses.
+type UAParser struct {
+	browsers []uaRule
+	oses     []uaRule
+	devices  []uaRule
+}
+
+// uaRule is one line of the rules.
+type uaRule struct {
+	name    string
+	version string
+	re      *regexp.Regexp
+}
+
+// LoadUAParser creates a UAParser with the rules in the given file.
+func LoadUAParser(path string) (*UAParser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := ReadUAParser(f)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return p, nil
+}
+
+// ReadUAParser creates a UAParser with the rules read from r.
+func ReadUAParser(r io.Reader) (*UAParser, error) {
+	p := &UAParser{}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf(
+				"line %v: expected a kind, name, version and pattern", n)
+		}
+
+		// The pattern is everything after the version.
+		pattern := line
+		for _, f := range fields[:3] {
+			pattern = strings.TrimSpace(strings.TrimPrefix(pattern, f))
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", n, err)
+		}
+
+		rule := uaRule{
+			name:    strings.Replace(fields[1], "_", " ", -1),
+			version: fields[2],
+			re:      re,
+		}
+
+		switch fields[0] {
+		case "browser":
+			p.browsers = append(p.browsers, rule)
+		case "os":
+			p.oses = append(p.oses, rule)
+		case "device":
+			p.devices = append(p.devices, rule)
+		default:
+			return nil, fmt.Errorf("line %v: unknown kind %q", n, fields[0])
+		}
+	}
+
+	return p, s.Err()
+}
+
+// Parse returns what the rules can tell about the given user agent.
+func (p *UAParser) Parse(ua string) UserAgent {
+	var a UserAgent
+	a.Browser, a.BrowserVersion = match(p.browsers, ua)
+	a.OS, a.OSVersion = match(p.oses, ua)
+	a.Device, _ = match(p.devices, ua)
+	return a
+}
+
+// match is a helper function that returns the name and version of the
+// first rule that matches the user agent.
+func match(rules []uaRule, ua string) (string, string) {
+	for _, r := range rules {
+		m := r.re.FindStringSubmatchIndex(ua)
+		if m == nil {
+			continue
+		}
+
+		if r.version == "-" {
+			return r.name, ""
+		}
+		return r.name, string(r.re.ExpandString(nil, r.version, ua, m))
+	}
+
+	return "Unknown", ""
+}
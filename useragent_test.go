@@ -0,0 +1,120 @@
+// Copyright 2013 Joshua Marsh. All rights reserved.
+// Use of this source code is governed by the MIT
+// license that can be found in the LICENSE file.
+
+package urls
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua       string
+		expected UserAgent
+	}{
+		{
+			ua:       "Mozilla/5.0 (Windows NT 6.1; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
+			expected: UserAgent{"Chrome", "28", "Windows", "7", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_4) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
+			expected: UserAgent{"Chrome", "28", "macOS", "10.8", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_8_4) AppleWebKit/536.30.1 (KHTML, like Gecko) Version/6.0.5 Safari/536.30.1",
+			expected: UserAgent{"Safari", "6", "macOS", "10.8", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Windows NT 6.1; WOW64; rv:23.0) Gecko/20100101 Firefox/23.0",
+			expected: UserAgent{"Firefox", "23", "Windows", "7", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Windows NT 6.2; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/28.0.1500.95 Safari/537.36",
+			expected: UserAgent{"Chrome", "28", "Windows", "8", "desktop"},
+		},
+		{
+			ua:       "Opera/9.80 (Windows NT 6.1; WOW64) Presto/2.12.388 Version/12.16",
+			expected: UserAgent{"Opera", "12", "Windows", "7", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			expected: UserAgent{"Edge", "120", "Windows", "10", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected: UserAgent{"Firefox", "121", "Linux", "", "desktop"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			expected: UserAgent{"Chrome", "120", "Android", "14", "mobile"},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Safari/537.36",
+			expected: UserAgent{"Samsung Internet", "23", "Android", "13", "tablet"},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			expected: UserAgent{"Safari", "17", "iOS", "17.2", "mobile"},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			expected: UserAgent{"Chrome", "120", "iOS", "16.6", "tablet"},
+		},
+		{
+			ua:       "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			expected: UserAgent{"Googlebot", "2", "Unknown", "", "bot"},
+		},
+		{
+			ua:       "curl/8.4.0",
+			expected: UserAgent{"curl", "8", "Unknown", "", "bot"},
+		},
+		{
+			ua:       "",
+			expected: UserAgent{"Unknown", "", "Unknown", "", "Unknown"},
+		},
+	}
+
+	for k, test := range tests {
+		if ua := DefaultUAParser.Parse(test.ua); ua != test.expected {
+			t.Errorf("Test %v: expected %+v but got %+v: %v",
+				k, test.expected, ua, test.ua)
+		}
+	}
+}
+
+func TestReadUAParser(t *testing.T) {
+	tests := []struct {
+		rules string
+		err   string
+	}{
+		{rules: "# comment\n\nbrowser Chrome $1 Chrome/(\\d+)\n"},
+		{rules: "browser Chrome $1\n", err: "line 1: expected"},
+		{rules: "# comment\nbrowser Chrome $1 Chrome/(\n", err: "line 2"},
+		{rules: "engine Blink - Chrome/\n", err: "unknown kind"},
+	}
+
+	for k, test := range tests {
+		_, err := ReadUAParser(strings.NewReader(test.rules))
+		if test.err == "" && err != nil {
+			t.Errorf("Test %v: ReadUAParser() failed: %v", k, err)
+		} else if test.err != "" &&
+			(err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Test %v: expected error %q, got %v", k, test.err, err)
+		}
+	}
+
+	// Names can have spaces and patterns can have anything.
+	p, err := ReadUAParser(strings.NewReader(
+		"browser  Big_Browser  $1.$2  Big Browser (\\d+)\\.(\\d+)  \n" +
+			"device   desktop      -      .\n"))
+	if err != nil {
+		t.Fatalf("ReadUAParser() failed: %v", err)
+	}
+
+	expected := UserAgent{"Big Browser", "3.1", "Unknown", "", "desktop"}
+	if ua := p.Parse("Big Browser 3.1.4"); ua != expected {
+		t.Errorf("expected %+v but got %+v", expected, ua)
+	}
+}